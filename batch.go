@@ -0,0 +1,132 @@
+package whois
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultBatchWorkers is the max concurrent lookups of a batch if not set
+const defaultBatchWorkers = 10
+
+// BatchOptions is the options of batch lookups
+type BatchOptions struct {
+	// Workers is the max concurrent lookups, 10 if not greater than 0,
+	// the per-server limits of the client limiter are applied as well
+	Workers int
+	// Timeout is the deadline of the whole batch, 0 means no deadline
+	Timeout time.Duration
+}
+
+// BatchResult is the whois result of a query in a batch
+type BatchResult struct {
+	// Index is the index of the query in the input
+	Index int
+	// Query is the input query
+	Query string
+	// Result is the structured result, nil if the query is not started before the batch is done
+	Result *QueryResult
+	// Err is the error of the query
+	Err error
+}
+
+// RDAPBatchResult is the RDAP result of a query in a batch
+type RDAPBatchResult struct {
+	// Index is the index of the query in the input
+	Index int
+	// Query is the input query
+	Query string
+	// Result is the structured result, nil if the query is not started before the batch is done
+	Result *RDAPResult
+	// Err is the error of the query
+	Err error
+}
+
+// WhoisBatch do the whois queries on a bounded worker pool,
+// the results are sent in completion order and the channel is closed after all the queries are done.
+// every query has a result, the queries not started before ctx is done or the timeout fail with the ctx error.
+func (c *Client) WhoisBatch(ctx context.Context, queries []string, opts BatchOptions) <-chan BatchResult {
+	results := make(chan BatchResult, len(queries))
+
+	go func() {
+		defer close(results)
+		runBatch(ctx, len(queries), opts, func(ctx context.Context, i int) {
+			r, err := c.QueryContext(ctx, queries[i])
+			results <- BatchResult{Index: i, Query: queries[i], Result: r, Err: err}
+		}, func(i int, err error) {
+			results <- BatchResult{Index: i, Query: queries[i], Err: err}
+		})
+	}()
+
+	return results
+}
+
+// RDAPBatch do the RDAP queries on a bounded worker pool,
+// the results are sent in completion order and the channel is closed after all the queries are done.
+// every query has a result, the queries not started before ctx is done or the timeout fail with the ctx error.
+func (c *RDAPClient) RDAPBatch(ctx context.Context, queries []string, opts BatchOptions) <-chan RDAPBatchResult {
+	results := make(chan RDAPBatchResult, len(queries))
+
+	go func() {
+		defer close(results)
+		runBatch(ctx, len(queries), opts, func(ctx context.Context, i int) {
+			r, err := c.QueryContext(ctx, queries[i])
+			results <- RDAPBatchResult{Index: i, Query: queries[i], Result: r, Err: err}
+		}, func(i int, err error) {
+			results <- RDAPBatchResult{Index: i, Query: queries[i], Err: err}
+		})
+	}()
+
+	return results
+}
+
+// runBatch runs do for the indexes from 0 to n-1 on the workers,
+// skip is called for the indexes not started before ctx is done
+func runBatch(ctx context.Context, n int, opts BatchOptions,
+	do func(ctx context.Context, i int), skip func(i int, err error)) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	if workers > n {
+		workers = n
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				do(ctx, i)
+			}
+		}()
+	}
+
+	i := 0
+dispatch:
+	for ; i < n; i++ {
+		// ctx结束后不再分发新的查询
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for ; i < n; i++ {
+		skip(i, ctx.Err())
+	}
+}
@@ -0,0 +1,224 @@
+package whois
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the max entries of a memory cache if the size is not set
+const defaultCacheSize = 10000
+
+// CacheStatus is the cache status of a lookup result
+type CacheStatus string
+
+const (
+	// CacheHit means the result is returned from the cache
+	CacheHit CacheStatus = "hit"
+	// CacheMiss means the result is queried and then stored to the cache
+	CacheMiss CacheStatus = "miss"
+)
+
+// Cache is the lookup result cache used by Client and RDAPClient,
+// the stored values are owned by the cache and must not be modified
+type Cache interface {
+	// Get returns the value of the key, ok is false if not found or expired
+	Get(key string) (value interface{}, ok bool)
+	// Set stores the value of the key for the ttl
+	Set(key string, value interface{}, ttl time.Duration)
+	// Delete removes the key
+	Delete(key string)
+}
+
+// CacheTTL is the cache time of the lookup results, 0 means not to cache
+type CacheTTL struct {
+	// Positive is the cache time of the found results
+	Positive time.Duration
+	// Negative is the cache time of the not found results
+	Negative time.Duration
+	// Error is the cache time of the failed lookups
+	Error time.Duration
+}
+
+// DefaultCacheTTL returns the cache ttl of 1 hour for found, 5 minutes for not found and 30 seconds for errors
+func DefaultCacheTTL() CacheTTL {
+	return CacheTTL{
+		Positive: time.Hour,
+		Negative: 5 * time.Minute,
+		Error:    30 * time.Second,
+	}
+}
+
+// MemoryCache is an in-memory LRU cache with ttl
+type MemoryCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+// memoryCacheItem is an entry of the memory cache
+type memoryCacheItem struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewMemoryCache returns a new memory cache with max size entries, the least recently used is evicted if full
+func NewMemoryCache(size int) *MemoryCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+
+	return &MemoryCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get returns the value of the key, ok is false if not found or expired
+func (m *MemoryCache) Get(key string) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.expires) {
+		m.remove(elem)
+		return nil, false
+	}
+
+	m.order.MoveToFront(elem)
+
+	return item.value, true
+}
+
+// Set stores the value of the key for the ttl, the key is removed if ttl is not greater than 0
+func (m *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.remove(elem)
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	m.items[key] = m.order.PushFront(&memoryCacheItem{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(ttl),
+	})
+
+	for m.order.Len() > m.size {
+		m.remove(m.order.Back())
+	}
+}
+
+// Delete removes the key
+func (m *MemoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.remove(elem)
+	}
+}
+
+// Len returns the number of entries including the expired ones not yet evicted
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.order.Len()
+}
+
+// Purge removes all the entries
+func (m *MemoryCache) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items = make(map[string]*list.Element)
+	m.order.Init()
+}
+
+// remove removes the element, m.mu must be held
+func (m *MemoryCache) remove(elem *list.Element) {
+	m.order.Remove(elem)
+	delete(m.items, elem.Value.(*memoryCacheItem).key)
+}
+
+// whoisCacheEntry is the cached whois lookup
+type whoisCacheEntry struct {
+	result *QueryResult
+	err    error
+}
+
+// rdapCacheEntry is the cached rdap lookup
+type rdapCacheEntry struct {
+	result *RDAPResult
+	err    error
+}
+
+// SetCache set the result cache of the queries, nil disables the cache
+func (c *Client) SetCache(cache Cache) *Client {
+	c.cache = cache
+	return c
+}
+
+// SetCacheTTL set the cache time of the results
+func (c *Client) SetCacheTTL(ttl CacheTTL) *Client {
+	c.cacheTTL = ttl
+	return c
+}
+
+// SetCache set the result cache of the queries, nil disables the cache
+func (c *RDAPClient) SetCache(cache Cache) *RDAPClient {
+	c.cache = cache
+	return c
+}
+
+// SetCacheTTL set the cache time of the results
+func (c *RDAPClient) SetCacheTTL(ttl CacheTTL) *RDAPClient {
+	c.cacheTTL = ttl
+	return c
+}
+
+// ttlOf returns the cache time of the result by the found, not found or error kind
+func (t CacheTTL) ttlOf(notFound bool, err error) time.Duration {
+	switch {
+	case err != nil && !notFound:
+		return t.Error
+	case notFound:
+		return t.Negative
+	default:
+		return t.Positive
+	}
+}
+
+// copyJSONValue returns a deep copy of the value decoded from json
+func copyJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[k] = copyJSONValue(vv)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, vv := range v {
+			s[i] = copyJSONValue(vv)
+		}
+		return s
+	default:
+		return v
+	}
+}
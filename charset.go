@@ -0,0 +1,176 @@
+package whois
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// defaultCharset is the charset of utf-8 and ascii responses
+const defaultCharset = "utf-8"
+
+// namedEncoding is an encoding with its canonical name
+type namedEncoding struct {
+	name     string
+	encoding encoding.Encoding
+}
+
+var (
+	gb18030Encoding     = namedEncoding{"gb18030", simplifiedchinese.GB18030}
+	big5Encoding        = namedEncoding{"big5", traditionalchinese.Big5}
+	shiftJISEncoding    = namedEncoding{"shift_jis", japanese.ShiftJIS}
+	eucJPEncoding       = namedEncoding{"euc-jp", japanese.EUCJP}
+	eucKREncoding       = namedEncoding{"euc-kr", korean.EUCKR}
+	koi8REncoding       = namedEncoding{"koi8-r", charmap.KOI8R}
+	windows1251Encoding = namedEncoding{"windows-1251", charmap.Windows1251}
+	iso88591Encoding    = namedEncoding{"iso-8859-1", charmap.ISO8859_1}
+	iso2022JPEncoding   = namedEncoding{"iso-2022-jp", japanese.ISO2022JP}
+)
+
+// multiByteEncodings are the multi-byte charsets to try, ordered by the extension hint
+var multiByteEncodings = map[string][]namedEncoding{
+	"cn": {gb18030Encoding, big5Encoding},
+	"tw": {big5Encoding, gb18030Encoding},
+	"hk": {big5Encoding, gb18030Encoding},
+	"mo": {big5Encoding, gb18030Encoding},
+	"jp": {shiftJISEncoding, eucJPEncoding},
+	"kr": {eucKREncoding},
+	"":   {gb18030Encoding, big5Encoding, shiftJISEncoding, eucJPEncoding, eucKREncoding},
+}
+
+// cyrillicExtensions are the extensions which responses may be in koi8-r or windows-1251
+var cyrillicExtensions = map[string]bool{
+	"ru": true, "su": true, "xn--p1ai": true, "ua": true, "by": true, "kz": true, "bg": true,
+}
+
+// decodeResponse converts the response to utf-8, the charset is used if set and known,
+// otherwise the charset is detected, with the domain extension as a hint.
+// the incomplete utf-8 sequence at the end of a truncated response is dropped.
+// returns the utf-8 text and the charset name.
+func decodeResponse(data []byte, charset, domain string, truncated bool) (string, string) {
+	if charset != "" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			name, _ := htmlindex.Name(enc)
+			if text, err := enc.NewDecoder().Bytes(data); err == nil {
+				return string(text), name
+			}
+		}
+	}
+
+	if bytes.Contains(data, []byte("\x1b$B")) || bytes.Contains(data, []byte("\x1b$@")) {
+		if text, ok := tryDecode(iso2022JPEncoding, data); ok {
+			return text, iso2022JPEncoding.name
+		}
+	}
+
+	// 截断可能切开最后一个多字节字符，去掉后再判断是否为utf-8，避免整个响应按latin1解码
+	valid := data
+	if truncated {
+		valid = trimIncompleteUTF8(data)
+	}
+	if utf8.Valid(valid) {
+		return string(valid), defaultCharset
+	}
+
+	ext := strings.ToLower(getExtension(domain))
+
+	if cyrillicExtensions[ext] {
+		return decodeCyrillic(data)
+	}
+
+	if !isSingleByteText(data) {
+		candidates, ok := multiByteEncodings[ext]
+		if !ok {
+			candidates = multiByteEncodings[""]
+		}
+		for _, v := range candidates {
+			if text, ok := tryDecode(v, data); ok {
+				return text, v.name
+			}
+		}
+	}
+
+	text, _ := iso88591Encoding.encoding.NewDecoder().Bytes(data)
+
+	return string(text), iso88591Encoding.name
+}
+
+// trimIncompleteUTF8 returns data without the incomplete utf-8 sequence at the end
+func trimIncompleteUTF8(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+
+	return data
+}
+
+// tryDecode decodes data with the encoding, fails if any invalid sequence is found
+func tryDecode(enc namedEncoding, data []byte) (string, bool) {
+	text, err := enc.encoding.NewDecoder().Bytes(data)
+	if err != nil || bytes.ContainsRune(text, utf8.RuneError) {
+		return "", false
+	}
+
+	for _, r := range string(text) {
+		// C1控制字符通常意味着编码猜错了
+		if r >= 0x80 && r <= 0x9f {
+			return "", false
+		}
+	}
+
+	return string(text), true
+}
+
+// decodeCyrillic decodes data as koi8-r or windows-1251, whichever has more lowercase letters,
+// because lowercase and uppercase letters are swapped between the two charsets
+func decodeCyrillic(data []byte) (string, string) {
+	best, bestName, bestScore := "", "", -1
+	for _, v := range []namedEncoding{koi8REncoding, windows1251Encoding} {
+		text, err := v.encoding.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(text) {
+			if unicode.Is(unicode.Cyrillic, r) && unicode.IsLower(r) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestName, bestScore = string(text), v.name, score
+		}
+	}
+
+	return best, bestName
+}
+
+// isSingleByteText returns if most non-ascii bytes are isolated between ascii bytes,
+// which happens with latin charsets but rarely with multi-byte charsets
+func isSingleByteText(data []byte) bool {
+	high, isolated := 0, 0
+	for i, b := range data {
+		if b < 0x80 {
+			continue
+		}
+		high++
+		if (i == 0 || data[i-1] < 0x80) && (i == len(data)-1 || data[i+1] < 0x80) {
+			isolated++
+		}
+	}
+
+	return high > 0 && isolated*2 > high
+}
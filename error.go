@@ -27,4 +27,13 @@ var (
 
 	// ErrWhoisServerNotFound is no whois server found
 	ErrWhoisServerNotFound = errors.New("whois: no whois server found for domain")
+
+	// ErrRWhoisBanner is the rwhois server does not send a %rwhois banner
+	ErrRWhoisBanner = errors.New("whois: invalid rwhois banner")
+
+	// ErrRDAPServerNotFound is no rdap server found for the query
+	ErrRDAPServerNotFound = errors.New("rdap: no rdap server found")
+
+	// ErrRDAPNotFound is the rdap server responds the resource is not found
+	ErrRDAPNotFound = errors.New("rdap: resource not found")
 )
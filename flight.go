@@ -0,0 +1,145 @@
+package whois
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// lookupGroup coalesces the concurrent identical lookups of all the clients
+var lookupGroup = &flightGroup{}
+
+// flightGroup runs only one call at a time for the same key, the other callers wait for the shared result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an in-flight call
+type flightCall struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+	dups    int
+	cancel  context.CancelFunc
+}
+
+// do runs fn for the key if there is no in-flight call of the key, otherwise waits for it.
+// fn runs with a context which is canceled only if all the callers are gone,
+// shared is true if the result is shared with the other callers.
+func (g *flightGroup) do(ctx context.Context, key string,
+	fn func(ctx context.Context) (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+		call.dups++
+	} else {
+		// 查询使用第一个调用方的context中的值，但不受其取消的影响
+		fctx, cancel := context.WithCancel(detachedContext{parent: ctx})
+		call = &flightCall{
+			done:    make(chan struct{}),
+			waiters: 1,
+			cancel:  cancel,
+		}
+		g.calls[key] = call
+		go g.run(fctx, key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		g.mu.Lock()
+		shared = call.dups > 0
+		g.mu.Unlock()
+		return call.value, call.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		// 所有调用方都已离开，取消查询，后续调用重新发起
+		if call.waiters == 0 {
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err(), ok
+	}
+}
+
+// run runs fn and wakes up the waiters
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall,
+	fn func(ctx context.Context) (interface{}, error)) {
+	defer call.cancel()
+
+	call.value, call.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+
+	close(call.done)
+}
+
+// detachedContext keeps the values of the parent context without its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline implements context.Context
+func (detachedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context
+func (detachedContext) Err() error {
+	return nil
+}
+
+// Value implements context.Context
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// flightKey returns the coalescing key of the lookup, only the clients with the same server map,
+// transport, limits and retry policy share the lookups
+func (c *Client) flightKey(key string) string {
+	return fmt.Sprintf("%s|%p|%s|%p|%d|%d|%t|%d|%+v", key, c.servers(), identity(c.transport), c.limiter,
+		c.timeout, c.maxResponseSize, c.disableReferral, c.maxReferralDepth, c.retryPolicy)
+}
+
+// flightKey returns the coalescing key of the lookup, only the clients with the same RDAP map,
+// http client and timeout share the lookups
+func (c *RDAPClient) flightKey(key string) string {
+	return fmt.Sprintf("%s|%p|%p|%d", key, c.servers(), c.httpClient, c.timeout)
+}
+
+// identity returns the identity of the transport, the address if it is a pointer, otherwise the value
+func identity(v interface{}) string {
+	if t, ok := v.(dialerTransport); ok {
+		return "dialer:" + identity(t.dialer)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Func, reflect.Map, reflect.Chan, reflect.Slice, reflect.UnsafePointer:
+		return fmt.Sprintf("%T:%x", v, rv.Pointer())
+	default:
+		return fmt.Sprintf("%T:%+v", v, v)
+	}
+}
@@ -0,0 +1,280 @@
+package whois
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IANATLDListURL is the list of all the TLDs in the IANA root zone database
+const IANATLDListURL = "https://data.iana.org/TLD/tlds-alpha-by-domain.txt"
+
+// defaultGenerateWorkers is the max concurrent IANA queries of the generator if not set
+const defaultGenerateWorkers = 4
+
+// GenerateOptions is the options of GenerateServerConfig
+type GenerateOptions struct {
+	// TLDs is the TLDs to query, the TLD list is fetched from TLDListURL if empty
+	TLDs []string
+	// TLDListURL is the url of the TLD list, IANATLDListURL if empty
+	TLDListURL string
+	// HTTPClient is used to fetch the TLD list, a client with 20s timeout if nil
+	HTTPClient *http.Client
+	// Workers is the max concurrent IANA queries, 4 if not greater than 0
+	Workers int
+}
+
+// ServerChange is a whois server changed by the generator
+type ServerChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ServerConfigDiff is the difference of the generated servers from the base config
+type ServerConfigDiff struct {
+	// Added is the TLDs which have a whois server now, keyed by TLD
+	Added map[string]string `json:"added"`
+	// Removed is the TLDs which are not in the root zone list any more, keyed by TLD with the old server
+	Removed map[string]string `json:"removed"`
+	// Changed is the TLDs whose whois server is changed
+	Changed map[string]ServerChange `json:"changed"`
+	// Failed is the TLDs failed to query with the error message, their servers are kept
+	Failed map[string]string `json:"failed"`
+	// Missing is the TLDs whose IANA response has no whois server, keyed by TLD with the kept server
+	Missing map[string]string `json:"missing"`
+}
+
+// Empty returns if the servers are not changed
+func (d *ServerConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns the report of the difference, one TLD per line in the order of TLD
+func (d *ServerConfigDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "added: %d, removed: %d, changed: %d, failed: %d, missing: %d\n",
+		len(d.Added), len(d.Removed), len(d.Changed), len(d.Failed), len(d.Missing))
+	for _, tld := range sortedKeys(d.Added) {
+		fmt.Fprintf(&b, "+ %s %s\n", tld, d.Added[tld])
+	}
+	for _, tld := range sortedKeys(d.Removed) {
+		fmt.Fprintf(&b, "- %s %s\n", tld, d.Removed[tld])
+	}
+	changed := make([]string, 0, len(d.Changed))
+	for tld := range d.Changed {
+		changed = append(changed, tld)
+	}
+	sort.Strings(changed)
+	for _, tld := range changed {
+		fmt.Fprintf(&b, "~ %s %s -> %s\n", tld, d.Changed[tld].Old, d.Changed[tld].New)
+	}
+	for _, tld := range sortedKeys(d.Failed) {
+		fmt.Fprintf(&b, "! %s %s\n", tld, d.Failed[tld])
+	}
+	for _, tld := range sortedKeys(d.Missing) {
+		fmt.Fprintf(&b, "? %s %s\n", tld, d.Missing[tld])
+	}
+
+	return b.String()
+}
+
+// GenerateServerConfig queries the whois server of every TLD from IANA and returns the refreshed config,
+// the multi-label suffixes and the other settings of base are kept, the servers of the failed TLDs are kept.
+// the servers of the TLDs without a whois server in the IANA response are kept, because the response may be partial.
+// if the TLD list is fetched, the TLDs of base which are not in the list are removed.
+// base is not modified, an empty config is used if nil.
+func (c *Client) GenerateServerConfig(ctx context.Context, base *ServerConfig,
+	opts GenerateOptions) (*ServerConfig, *ServerConfigDiff, error) {
+	tlds := make([]string, 0, len(opts.TLDs))
+	for _, tld := range opts.TLDs {
+		tlds = append(tlds, strings.ToLower(strings.Trim(strings.TrimSpace(tld), ".")))
+	}
+	fetched := len(tlds) == 0
+	if fetched {
+		var err error
+		tlds, err = fetchTLDList(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	config := copyServerConfig(base)
+	diff := &ServerConfigDiff{
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string]ServerChange),
+		Failed:  make(map[string]string),
+		Missing: make(map[string]string),
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultGenerateWorkers
+	}
+	for r := range c.WhoisBatch(ctx, tlds, BatchOptions{Workers: workers}) {
+		tld := tlds[r.Index]
+		if r.Err != nil || r.Result == nil || r.Result.Final == nil {
+			err := r.Err
+			if err == nil {
+				err = ErrWhoisServerNotFound
+			}
+			diff.Failed[tld] = err.Error()
+			continue
+		}
+
+		server, _ := getServer(r.Result.Final.Response)
+		server = strings.ToLower(server)
+		old, exists := config.Servers[tld]
+		switch {
+		case server == "" && exists:
+			// IANA的响应可能不完整，只有不在根区列表中的TLD才删除
+			diff.Missing[tld] = old
+		case server == "":
+		case !exists:
+			diff.Added[tld] = server
+			config.Servers[tld] = server
+		case old != server:
+			diff.Changed[tld] = ServerChange{Old: old, New: server}
+			config.Servers[tld] = server
+		}
+	}
+
+	// 整个生成被取消时不返回不完整的配置
+	if err := ctx.Err(); err != nil {
+		return nil, diff, err
+	}
+
+	// 根区列表中已不存在的顶级域名被删除，多级后缀如co.uk保留
+	if fetched {
+		listed := make(map[string]bool, len(tlds))
+		for _, tld := range tlds {
+			listed[tld] = true
+		}
+		for _, suffix := range sortedKeys(config.Servers) {
+			if strings.Contains(suffix, ".") || listed[suffix] {
+				continue
+			}
+			diff.Removed[suffix] = config.Servers[suffix]
+			delete(config.Servers, suffix)
+		}
+	}
+
+	return config, diff, nil
+}
+
+// LoadServerConfig reads and validates the server config from the file, the embedded config is used if empty
+func LoadServerConfig(filename string) (*ServerConfig, error) {
+	return loadServerConfig(filename)
+}
+
+// WriteServerConfig writes the server config to the file atomically
+func WriteServerConfig(filename string, config *ServerConfig) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(config); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, buf.Bytes())
+}
+
+// fetchTLDList returns the lowercase TLDs of the list, the comments are skipped
+func fetchTLDList(ctx context.Context, opts GenerateOptions) ([]string, error) {
+	url := opts.TLDListURL
+	if url == "" {
+		url = IANATLDListURL
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 20 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("whois: fetch tld list %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whois: fetch tld list %s failed: status code error: %d", url, resp.StatusCode)
+	}
+
+	var tlds []string
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, defaultMaxResponseSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tlds = append(tlds, strings.ToLower(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("whois: read tld list %s failed: %w", url, err)
+	}
+	if len(tlds) == 0 {
+		return nil, fmt.Errorf("whois: tld list %s is empty", url)
+	}
+
+	getLogger().Info("tld list fetched", "url", url, "tlds", len(tlds))
+
+	return tlds, nil
+}
+
+// copyServerConfig returns a copy of the exported fields of the config
+func copyServerConfig(base *ServerConfig) *ServerConfig {
+	config := &ServerConfig{
+		Rewrite:   make(map[string]string),
+		Servers:   make(map[string]string),
+		IP:        make(map[string]string),
+		ASN:       make(map[string]string),
+		Fallbacks: make(map[string][]string),
+		Options:   make(map[string]ServerOptions),
+	}
+	if base == nil {
+		return config
+	}
+
+	for k, v := range base.Rewrite {
+		config.Rewrite[k] = v
+	}
+	for k, v := range base.Servers {
+		config.Servers[k] = v
+	}
+	for k, v := range base.IP {
+		config.IP[k] = v
+	}
+	for k, v := range base.ASN {
+		config.ASN[k] = v
+	}
+	for k, v := range base.Fallbacks {
+		config.Fallbacks[k] = append([]string(nil), v...)
+	}
+	for k, v := range base.Options {
+		config.Options[k] = v
+	}
+
+	return config
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -9,6 +9,7 @@ require (
 	github.com/valyala/fasthttp v1.52.0
 	github.com/yl2chen/cidranger v1.0.2
 	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -24,5 +25,4 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 )
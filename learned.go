@@ -0,0 +1,206 @@
+package whois
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// learnedServersFilename is the file name of the learned servers next to servers.json
+const learnedServersFilename = "servers.learned.json"
+
+// LearnedServer is a whois server of a suffix discovered from IANA
+type LearnedServer struct {
+	// Server is the whois server
+	Server string `json:"server"`
+	// LearnedAt is the time the server is discovered
+	LearnedAt time.Time `json:"learned_at"`
+	// TTL is the time to keep the server, 0 means forever
+	TTL Duration `json:"ttl,omitempty"`
+}
+
+// Expired returns if the learned server is expired at the time
+func (l LearnedServer) Expired(now time.Time) bool {
+	return l.TTL > 0 && now.After(l.LearnedAt.Add(time.Duration(l.TTL)))
+}
+
+// learnedServersFile is the json file of the learned servers
+type learnedServersFile struct {
+	Servers map[string]LearnedServer `json:"servers"`
+}
+
+// LearnedServersFile returns the learned servers file next to the servers config file,
+// in the working directory if the embedded config is used
+func LearnedServersFile(configFile string) string {
+	if configFile == "" {
+		return learnedServersFilename
+	}
+
+	return filepath.Join(filepath.Dir(configFile), learnedServersFilename)
+}
+
+// EnableLearnedServers persists the servers learned by the default server map to the file,
+// the servers saved in the file are loaded, ttl is the time to keep a learned server, 0 means forever
+func EnableLearnedServers(filename string, ttl time.Duration) error {
+	return defaultServerMap().EnableLearnedServers(filename, ttl)
+}
+
+// ExportLearnedServers returns the unexpired servers learned by the default server map, keyed by suffix
+func ExportLearnedServers() map[string]LearnedServer {
+	return defaultServerMap().LearnedServers()
+}
+
+// ImportLearnedServers merges the learned servers to the default server map
+func ImportLearnedServers(servers map[string]LearnedServer) error {
+	return defaultServerMap().ImportLearnedServers(servers)
+}
+
+// ExpireLearnedServers removes the expired learned servers of the default server map,
+// returns the number of removed servers
+func ExpireLearnedServers() (int, error) {
+	return defaultServerMap().ExpireLearnedServers(time.Now())
+}
+
+// EnableLearnedServers persists the learned servers to the file and loads the servers saved in it,
+// ttl is the time to keep a learned server, 0 means forever
+func (sm *ServerMap) EnableLearnedServers(filename string, ttl time.Duration) error {
+	saved := map[string]LearnedServer{}
+	data, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var file learnedServersFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return err
+		}
+		saved = file.Servers
+	}
+
+	sm.Lock()
+	sm.learnedFile = filename
+	sm.learnedTTL = ttl
+	sm.mergeLearned(saved)
+	sm.Unlock()
+
+	return nil
+}
+
+// LearnedServers returns the unexpired learned servers keyed by suffix
+func (sm *ServerMap) LearnedServers() map[string]LearnedServer {
+	sm.RLock()
+	defer sm.RUnlock()
+
+	now := time.Now()
+	servers := make(map[string]LearnedServer, len(sm.learned))
+	for suffix, v := range sm.learned {
+		if !v.Expired(now) {
+			servers[suffix] = v
+		}
+	}
+
+	return servers
+}
+
+// ImportLearnedServers merges the learned servers, the later learned one wins if the suffix exists
+func (sm *ServerMap) ImportLearnedServers(servers map[string]LearnedServer) error {
+	sm.Lock()
+	sm.mergeLearned(servers)
+	sm.Unlock()
+
+	return sm.saveLearned()
+}
+
+// ExpireLearnedServers removes the learned servers expired at the time, returns the number of removed servers
+func (sm *ServerMap) ExpireLearnedServers(now time.Time) (int, error) {
+	sm.Lock()
+	n := 0
+	for suffix, v := range sm.learned {
+		if v.Expired(now) {
+			delete(sm.learned, suffix)
+			n++
+		}
+	}
+	sm.Unlock()
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, sm.saveLearned()
+}
+
+// learnServer adds the server discovered for the suffix, returns false if the suffix has a server already
+func (sm *ServerMap) learnServer(suffix, server string) bool {
+	sm.Lock()
+	if _, exists := sm.config.Servers[suffix]; exists {
+		sm.Unlock()
+		return false
+	}
+	if v, exists := sm.learned[suffix]; exists && !v.Expired(time.Now()) {
+		sm.Unlock()
+		return false
+	}
+	sm.learned[suffix] = LearnedServer{
+		Server:    server,
+		LearnedAt: time.Now(),
+		TTL:       Duration(sm.learnedTTL),
+	}
+	sm.Unlock()
+
+	if err := sm.saveLearned(); err != nil {
+		getLogger().Warn("save learned servers failed", "suffix", suffix, "server", server, "error", err)
+	}
+
+	return true
+}
+
+// getLearned returns the unexpired learned server of the suffix, sm must be locked
+func (sm *ServerMap) getLearned(suffix string) (string, bool) {
+	v, exists := sm.learned[suffix]
+	if !exists || v.Expired(time.Now()) {
+		return "", false
+	}
+
+	return v.Server, true
+}
+
+// mergeLearned merges the learned servers, the later learned one wins, sm must be locked
+func (sm *ServerMap) mergeLearned(servers map[string]LearnedServer) {
+	for suffix, v := range servers {
+		if v.Server == "" {
+			continue
+		}
+		if old, exists := sm.learned[suffix]; exists && old.LearnedAt.After(v.LearnedAt) {
+			continue
+		}
+		sm.learned[suffix] = v
+	}
+}
+
+// saveLearned writes the learned servers to the file if persistence is enabled
+func (sm *ServerMap) saveLearned() error {
+	sm.learnedMu.Lock()
+	defer sm.learnedMu.Unlock()
+
+	sm.RLock()
+	filename := sm.learnedFile
+	file := learnedServersFile{Servers: make(map[string]LearnedServer, len(sm.learned))}
+	for suffix, v := range sm.learned {
+		file.Servers[suffix] = v
+	}
+	sm.RUnlock()
+
+	if filename == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, data)
+}
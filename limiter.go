@@ -0,0 +1,342 @@
+package whois
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLimitPenalty is the first pause after a limit exceeded response
+	defaultLimitPenalty = 5 * time.Second
+	// maxLimitPenalty is the max pause after continuous limit exceeded responses
+	maxLimitPenalty = 10 * time.Minute
+	// limiterIdleTimeout is the idle time after which the state of a server is evicted
+	limiterIdleTimeout = 30 * time.Minute
+)
+
+// DefaultLimiter is the limiter shared by the clients created by NewClient
+var DefaultLimiter = NewLimiter(RateLimit{})
+
+// RateLimit is the request budget of a whois server
+type RateLimit struct {
+	// Rate is the queries per second allowed, 0 means no limit
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is the max queries at once when the budget is full, 1 if not greater than 0
+	Burst int `json:"burst,omitempty"`
+	// MaxConcurrent is the max concurrent queries, 0 means no limit
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// LimiterState is the state of the limiter of a whois server
+type LimiterState struct {
+	Server        string    `json:"server"`
+	Limit         RateLimit `json:"limit"`
+	Tokens        float64   `json:"tokens"`
+	Active        int       `json:"active"`
+	Waiting       int       `json:"waiting"`
+	PausedUntil   time.Time `json:"paused_until,omitempty"`
+	Penalty       Duration  `json:"penalty,omitempty"`
+	Queries       uint64    `json:"queries"`
+	LimitExceeded uint64    `json:"limit_exceeded"`
+}
+
+// Limiter limits the queries per whois server by token bucket and concurrency,
+// and pauses a server after it responds limit exceeded.
+// the state of a server is shared by all the clients using the limiter, a changed rate limit of the server
+// is applied only when the server has no active or waiting queries, so the clients sharing a limiter
+// should use the same rate limit for a server. the state of a server idle for 30 minutes is evicted.
+type Limiter struct {
+	mu        sync.Mutex
+	defaults  RateLimit
+	servers   map[string]*serverLimiter
+	lastSweep time.Time
+}
+
+// serverLimiter is the limiter state of a whois server
+type serverLimiter struct {
+	limit         RateLimit
+	tokens        float64
+	last          time.Time
+	sem           chan struct{}
+	active        int
+	waiting       int
+	pausedUntil   time.Time
+	penalty       time.Duration
+	queries       uint64
+	limitExceeded uint64
+	used          time.Time
+}
+
+// NewLimiter returns a new limiter, defaults is used for servers without a configured rate limit
+func NewLimiter(defaults RateLimit) *Limiter {
+	return &Limiter{
+		defaults:  defaults,
+		servers:   make(map[string]*serverLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimiter set the limiter of the queries, nil disables the limiting
+func (c *Client) SetLimiter(limiter *Limiter) *Client {
+	c.limiter = limiter
+	return c
+}
+
+// Limiter returns the limiter of the client
+func (c *Client) Limiter() *Limiter {
+	return c.limiter
+}
+
+// waitLimiter waits for the limiter of the hop server and sets the hop wait time,
+// the returned func must be called with the finished hop
+func (c *Client) waitLimiter(ctx context.Context, hop *QueryHop, options ServerOptions) (func(QueryHop), error) {
+	start := time.Now()
+	release, err := c.limiter.Wait(ctx, hop.Server, options.RateLimit)
+	hop.WaitTime = time.Since(start)
+	if err != nil {
+		hop.Err = fmt.Errorf("whois: wait for rate limit of whois server (%s) failed: %w", hop.Server, err)
+		hop.failure = ioFailure(err)
+		return nil, err
+	}
+
+	return func(hop QueryHop) {
+		release()
+		c.limiter.Observe(hop)
+	}, nil
+}
+
+// Wait waits until the server can be queried, limit is used if not nil.
+// the returned func must be called after the query is done.
+func (l *Limiter) Wait(ctx context.Context, server string, limit *RateLimit) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	s := l.get(server, limit)
+	s.waiting++
+	sem := s.sem
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		s.waiting--
+		l.mu.Unlock()
+	}
+
+	// 先获取并发槽位，再等待令牌
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+
+	for {
+		l.mu.Lock()
+		delay := s.take(time.Now())
+		if delay <= 0 {
+			s.waiting--
+			s.active++
+			s.queries++
+			l.mu.Unlock()
+			break
+		}
+		l.mu.Unlock()
+
+		if !sleepContext(ctx, delay) {
+			if sem != nil {
+				<-sem
+			}
+			done()
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			s.active--
+			l.mu.Unlock()
+			if sem != nil {
+				<-sem
+			}
+		})
+	}, nil
+}
+
+// Observe updates the server state by the hop, pauses the server if it responds limit exceeded
+func (l *Limiter) Observe(hop QueryHop) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.servers[hop.Server]
+	if !ok {
+		return
+	}
+
+	if hopFailure(hop) == RetryLimitExceeded {
+		s.limitExceeded++
+		if s.penalty == 0 {
+			s.penalty = defaultLimitPenalty
+		} else if s.penalty < maxLimitPenalty {
+			s.penalty *= 2
+			if s.penalty > maxLimitPenalty {
+				s.penalty = maxLimitPenalty
+			}
+		}
+		s.pausedUntil = time.Now().Add(s.penalty)
+		s.tokens = 0
+		return
+	}
+
+	// 成功后逐步恢复
+	if hop.Err == nil && s.penalty > 0 {
+		s.penalty /= 2
+		if s.penalty < defaultLimitPenalty {
+			s.penalty = 0
+		}
+	}
+}
+
+// State returns the state of the server limiter
+func (l *Limiter) State(server string) (LimiterState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.servers[server]
+	if !ok {
+		return LimiterState{}, false
+	}
+
+	return s.state(server), true
+}
+
+// States returns the states of all the server limiters, sorted by server
+func (l *Limiter) States() []LimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	states := make([]LimiterState, 0, len(l.servers))
+	for server, s := range l.servers {
+		states = append(states, s.state(server))
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Server < states[j].Server
+	})
+
+	return states
+}
+
+// get returns the server limiter, creates it by the limit, or updates it if it is idle, l.mu must be held
+func (l *Limiter) get(server string, limit *RateLimit) *serverLimiter {
+	if limit == nil {
+		limit = &l.defaults
+	}
+
+	now := time.Now()
+	l.evict(now)
+
+	s, ok := l.servers[server]
+	if !ok {
+		s = &serverLimiter{
+			last: now,
+		}
+		l.servers[server] = s
+	}
+	s.used = now
+
+	// 有查询在使用时保持当前配置，避免不同配置的客户端交替重建并发槽位和令牌
+	if !ok || (s.limit != *limit && s.active == 0 && s.waiting == 0) {
+		if !ok || s.limit.MaxConcurrent != limit.MaxConcurrent {
+			s.sem = nil
+			if limit.MaxConcurrent > 0 {
+				s.sem = make(chan struct{}, limit.MaxConcurrent)
+			}
+		}
+		s.limit = *limit
+		if !ok {
+			s.tokens = float64(s.burst())
+		} else if burst := float64(s.burst()); s.tokens > burst {
+			s.tokens = burst
+		}
+	}
+
+	return s
+}
+
+// evict removes the states of the servers idle for limiterIdleTimeout, l.mu must be held
+func (l *Limiter) evict(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for server, s := range l.servers {
+		// 暂停中的服务器保留状态，避免绕过惩罚
+		if s.active == 0 && s.waiting == 0 && now.Sub(s.used) >= limiterIdleTimeout && !now.Before(s.pausedUntil) {
+			delete(l.servers, server)
+		}
+	}
+}
+
+// burst returns the bucket size
+func (s *serverLimiter) burst() int {
+	if s.limit.Burst > 0 {
+		return s.limit.Burst
+	}
+	return 1
+}
+
+// take takes a token, returns the time to wait if no token is available
+func (s *serverLimiter) take(now time.Time) time.Duration {
+	if now.Before(s.pausedUntil) {
+		return s.pausedUntil.Sub(now)
+	}
+
+	if s.limit.Rate <= 0 {
+		return 0
+	}
+
+	s.tokens += now.Sub(s.last).Seconds() * s.limit.Rate
+	if burst := float64(s.burst()); s.tokens > burst {
+		s.tokens = burst
+	}
+	s.last = now
+
+	if s.tokens >= 1 {
+		s.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - s.tokens) / s.limit.Rate * float64(time.Second))
+}
+
+// state returns the state of the server limiter
+func (s *serverLimiter) state(server string) LimiterState {
+	state := LimiterState{
+		Server:        server,
+		Limit:         s.limit,
+		Tokens:        s.tokens,
+		Active:        s.active,
+		Waiting:       s.waiting,
+		Penalty:       Duration(s.penalty),
+		Queries:       s.queries,
+		LimitExceeded: s.limitExceeded,
+	}
+	if time.Now().Before(s.pausedUntil) {
+		state.PausedUntil = s.pausedUntil
+	}
+
+	return state
+}
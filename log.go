@@ -0,0 +1,172 @@
+package whois
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger is the structured logger of the diagnostics, it is compatible with *slog.Logger,
+// args are alternating keys and values such as "query", "example.com", "server", "whois.verisign-grs.com"
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// LogLevel is the level of the log messages, the values are the same as slog.Level
+type LogLevel int
+
+const (
+	// LogLevelDebug is the level of the per-hop and per-lookup details
+	LogLevelDebug LogLevel = -4
+	// LogLevelInfo is the level of the config loading
+	LogLevelInfo LogLevel = 0
+	// LogLevelWarn is the level of the recoverable failures
+	LogLevelWarn LogLevel = 4
+	// LogLevelError is the level of the failures
+	LogLevelError LogLevel = 8
+)
+
+// String returns the name of the level
+func (l LogLevel) String() string {
+	switch {
+	case l <= LogLevelDebug:
+		return "DEBUG"
+	case l <= LogLevelInfo:
+		return "INFO"
+	case l <= LogLevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLogLevel returns the level of the name, such as debug, info, warn or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info", "":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	default:
+		return 0, fmt.Errorf("whois: invalid log level %q", name)
+	}
+}
+
+// loggerHolder holds the package logger in an atomic.Value
+type loggerHolder struct {
+	logger Logger
+}
+
+// defaultLogger is the logger of the default maps and the clients without a logger
+var defaultLogger atomic.Value
+
+// SetLogger sets the package logger, which is used by the server maps and the clients without a logger,
+// nil disables the logging, the default is silent
+func SetLogger(logger Logger) {
+	defaultLogger.Store(loggerHolder{logger: logger})
+}
+
+// getLogger returns the package logger, a silent one if not set
+func getLogger() Logger {
+	if v, ok := defaultLogger.Load().(loggerHolder); ok && v.logger != nil {
+		return v.logger
+	}
+
+	return nopLogger{}
+}
+
+// nopLogger discards all the messages
+type nopLogger struct{}
+
+// Debug implements Logger
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+
+// Info implements Logger
+func (nopLogger) Info(msg string, args ...interface{}) {}
+
+// Warn implements Logger
+func (nopLogger) Warn(msg string, args ...interface{}) {}
+
+// Error implements Logger
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// stdLogger writes the messages not below the level to a standard logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger returns a Logger which writes the messages not below the level to the standard logger,
+// in the format of "LEVEL msg key=value ...", log.Default() is used if logger is nil
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &stdLogger{logger: logger, level: level}
+}
+
+// Debug implements Logger
+func (l *stdLogger) Debug(msg string, args ...interface{}) {
+	l.log(LogLevelDebug, msg, args)
+}
+
+// Info implements Logger
+func (l *stdLogger) Info(msg string, args ...interface{}) {
+	l.log(LogLevelInfo, msg, args)
+}
+
+// Warn implements Logger
+func (l *stdLogger) Warn(msg string, args ...interface{}) {
+	l.log(LogLevelWarn, msg, args)
+}
+
+// Error implements Logger
+func (l *stdLogger) Error(msg string, args ...interface{}) {
+	l.log(LogLevelError, msg, args)
+}
+
+// log formats and writes the message
+func (l *stdLogger) log(level LogLevel, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		b.WriteByte(' ')
+		// 缺少value时按slog的方式使用!BADKEY
+		if i+1 >= len(args) {
+			b.WriteString("!BADKEY=")
+			b.WriteString(formatLogValue(args[i]))
+			break
+		}
+		b.WriteString(fmt.Sprint(args[i]))
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(args[i+1]))
+	}
+
+	_ = l.logger.Output(3, b.String())
+}
+
+// formatLogValue returns the value string, quoted if it is empty or has spaces
+func formatLogValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
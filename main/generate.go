@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/darkqiank/whois"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// runGenerate refreshes the whois servers of all the TLDs from IANA and writes the servers file
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s generate [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Refresh the whois servers of all the TLDs from IANA and write the servers file.")
+		fs.PrintDefaults()
+	}
+
+	// 基础配置，为空时使用内置配置
+	basePath := fs.String("s", "", "Path to the base servers file, empty to use the embedded file.")
+	outputPath := fs.String("o", "", "Path to write the generated servers file, the base file if empty.")
+	tlds := fs.String("tlds", "", "Comma separated TLDs to query, empty to query all the TLDs in the IANA list.")
+	tldListURL := fs.String("tld-list", whois.IANATLDListURL, "URL of the IANA TLD list.")
+	workers := fs.Int("workers", 4, "Max concurrent queries to IANA.")
+	timeout := fs.Duration("timeout", 30*time.Minute, "Timeout of the whole generation.")
+	dryRun := fs.Bool("dry-run", false, "Only print the diff report without writing the file.")
+	_ = fs.Parse(args)
+
+	output := *outputPath
+	if output == "" {
+		output = *basePath
+	}
+	if output == "" && !*dryRun {
+		log.Fatalf("Error in generate: -o is required when the embedded servers file is used")
+	}
+
+	base, err := whois.LoadServerConfig(*basePath)
+	if err != nil {
+		log.Fatalf("Error in LoadServerConfig: %s", err)
+	}
+
+	opts := whois.GenerateOptions{
+		TLDListURL: *tldListURL,
+		Workers:    *workers,
+	}
+	if *tlds != "" {
+		opts.TLDs = strings.Split(*tlds, ",")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	config, diff, err := whois.NewClient().GenerateServerConfig(ctx, base, opts)
+	if err != nil {
+		log.Fatalf("Error in GenerateServerConfig: %s", err)
+	}
+	fmt.Print(diff.String())
+
+	if *dryRun {
+		return
+	}
+	if err := whois.WriteServerConfig(output, config); err != nil {
+		log.Fatalf("Error in WriteServerConfig: %s", err)
+	}
+	log.Printf("Servers file written to %s", output)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/darkqiank/whois"
@@ -8,9 +9,19 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// generate子命令从IANA重新生成servers文件
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		runGenerate(os.Args[2:])
+		return
+	}
+
 	// 定义命令行参数
 	// 第一个参数是命令行标志的名字，第二个参数是默认值，第三个参数是使用说明
 
@@ -18,7 +29,30 @@ func main() {
 	serversPath := flag.String("s", "", "Path to the servers file.")
 
 	// rdap服务config路径
-	rdapPath := flag.String("r", "", "Path to the rdap file. set online to init from iana")
+	rdapPath := flag.String("r", "", "Path to the rdap file. set online to init from iana, or the base url of a mirror")
+
+	// 在线获取rdap bootstrap时的刷新间隔和本地缓存文件
+	rdapRefresh := flag.Duration("rdap-refresh", 24*time.Hour, "Interval to refresh the rdap bootstrap when -r is online or a url, 0 to fetch only once.")
+	rdapCache := flag.String("rdap-cache", "rdap.bootstrap.json", "Path to keep the last good rdap bootstrap, used if the fetch fails, empty to disable.")
+
+	// 公共后缀列表路径，为空时使用内置列表
+	pslPath := flag.String("psl", "", "Path to the public suffix list file, empty to use the embedded list.")
+
+	// 持久化从IANA发现的whois服务器，保存在servers文件旁
+	persistLearned := flag.Bool("learned", false, "Persist the whois servers learned from IANA next to the servers file.")
+	learnedTTL := flag.Duration("learned-ttl", 30*24*time.Hour, "Time to keep a learned whois server, 0 to keep forever.")
+
+	// 查询结果缓存的大小和缓存时间
+	cacheSize := flag.Int("cache", 10000, "Max entries of the lookup cache, 0 to disable the cache.")
+	cacheTTL := flag.Duration("cache-ttl", time.Hour, "Cache time of the found results.")
+	cacheNegativeTTL := flag.Duration("cache-negative-ttl", 5*time.Minute, "Cache time of the not found results.")
+	cacheErrorTTL := flag.Duration("cache-error-ttl", 30*time.Second, "Cache time of the failed lookups.")
+
+	// 配置文件变化检查间隔，修改后自动重新加载，收到SIGHUP时也会重新加载
+	watchInterval := flag.Duration("watch", 0, "Interval to check the servers and rdap files and reload them if modified, 0 to disable. SIGHUP reloads them as well.")
+
+	// 日志级别，debug时输出每次查询的详细信息
+	logLevel := flag.String("log-level", "info", "Log level of the lookups and the config loading: debug, info, warn or error.")
 
 	// 新增端口号命令行参数
 	server_port := flag.String("p", "8080", "Port on which the server will run.")
@@ -26,11 +60,72 @@ func main() {
 	// 解析命令行参数
 	flag.Parse()
 
+	level, err := whois.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Error in ParseLogLevel: %s", err)
+	}
+	whois.SetLogger(whois.NewStdLogger(log.Default(), level))
+
+	if err := whois.LoadPublicSuffixList(*pslPath); err != nil {
+		log.Fatalf("Error in LoadPublicSuffixList: %s", err)
+	}
+
 	whois.InitWhois(*serversPath)
-	whois.InitRDAP(*rdapPath)
+
+	online := *rdapPath == "online" || strings.HasPrefix(*rdapPath, "http://") || strings.HasPrefix(*rdapPath, "https://")
+	if online && *rdapRefresh > 0 {
+		baseURL := *rdapPath
+		if baseURL == "online" {
+			baseURL = whois.DefaultRDAPBootstrapURL
+		}
+		whois.EnableRDAPBootstrapRefresh(context.Background(), whois.BootstrapRefreshOptions{
+			BaseURL:   baseURL,
+			Interval:  *rdapRefresh,
+			CacheFile: *rdapCache,
+		})
+	} else {
+		whois.InitRDAP(*rdapPath)
+	}
+
+	if *persistLearned {
+		learnedFile := whois.LearnedServersFile(*serversPath)
+		if err := whois.EnableLearnedServers(learnedFile, *learnedTTL); err != nil {
+			log.Fatalf("Error in EnableLearnedServers: %s", err)
+		}
+	}
+
+	if *cacheSize > 0 {
+		server.SetCache(whois.NewMemoryCache(*cacheSize), whois.CacheTTL{
+			Positive: *cacheTTL,
+			Negative: *cacheNegativeTTL,
+			Error:    *cacheErrorTTL,
+		})
+	} else {
+		server.SetCache(nil, whois.CacheTTL{})
+	}
+
+	// 收到SIGHUP时重新加载配置，失败时保留原配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logReload(whois.Reload())
+		}
+	}()
+
+	if *watchInterval > 0 {
+		go whois.WatchConfig(context.Background(), *watchInterval, logReload)
+	}
 
 	app := fiber.New()
 
+	// RDAP bootstrap刷新状态
+	app.Get("/_status/rdap-bootstrap", server.RDAPBootstrapStatusHandler)
+
+	// RDAP nameserver和注册商路由，需要在/rdap/*之前注册
+	app.Get("/rdap/nameserver/*", server.RdapNameserverHandler)
+	app.Get("/rdap/registrar/:id", server.RdapRegistrarHandler)
+
 	// RDAP路由
 	app.Get("/rdap/*", server.RdapHandler)
 
@@ -61,3 +156,12 @@ __          ___             _____        _  ___
 		log.Fatalf("Error in ListenAndServe: %s", err)
 	}
 }
+
+// logReload logs the result of a config reload
+func logReload(err error) {
+	if err != nil {
+		log.Printf("Error in Reload, keep the current config: %s", err)
+		return
+	}
+	log.Println("Config reloaded")
+}
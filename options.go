@@ -0,0 +1,50 @@
+package whois
+
+import (
+	"net/http"
+
+	"golang.org/x/net/proxy"
+)
+
+// ClientOption is the option of NewClient
+type ClientOption func(*Client)
+
+// RDAPClientOption is the option of NewRDAPClient
+type RDAPClientOption func(*RDAPClient)
+
+// WithServerMap sets the whois server map of the client instead of the default one,
+// so that the clients with different configs can live in one process
+func WithServerMap(sm *ServerMap) ClientOption {
+	return func(c *Client) {
+		c.serverMap = sm
+	}
+}
+
+// WithDialer sets the query net dialer of the client, it replaces the transport
+func WithDialer(dialer proxy.Dialer) ClientOption {
+	return func(c *Client) {
+		c.SetDialer(dialer)
+	}
+}
+
+// WithTransport sets the transport of the client
+func WithTransport(transport Transport) ClientOption {
+	return func(c *Client) {
+		c.SetTransport(transport)
+	}
+}
+
+// WithRDAPMap sets the RDAP server map of the client instead of the default one,
+// so that the clients with different configs can live in one process
+func WithRDAPMap(rm *RdapMap) RDAPClientOption {
+	return func(c *RDAPClient) {
+		c.rdapMap = rm
+	}
+}
+
+// WithHTTPClient sets the http client of the RDAP queries
+func WithHTTPClient(client *http.Client) RDAPClientOption {
+	return func(c *RDAPClient) {
+		c.httpClient = client
+	}
+}
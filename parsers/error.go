@@ -158,3 +158,18 @@ func isLimitExceeded(data string) bool {
 
 	return containsIn(strings.ToLower(data), limitExceedKeys)
 }
+
+// IsLimitExceeded returns if the whois response says the query limit is exceeded
+func IsLimitExceeded(data string) bool {
+	return isLimitExceeded(data)
+}
+
+// IsNotFound returns if the whois response says the domain is not found
+func IsNotFound(data string) bool {
+	name, extension := searchDomain(data)
+	if name == "" {
+		return isNotFoundDomain(data)
+	}
+
+	return extension != "" && isExtNotFoundDomain(data, extension)
+}
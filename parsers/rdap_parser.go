@@ -2,6 +2,7 @@ package parsers
 
 import (
 	"fmt"
+	"strings"
 )
 
 func ParseRDAPResponse(result map[string]interface{}) (RDAPInfo, error) {
@@ -19,6 +20,16 @@ func ParseRDAPResponse(result map[string]interface{}) (RDAPInfo, error) {
 			if err == nil {
 				rdap.Data = data
 			}
+		} else if rdap.Type == "nameserver" {
+			data, err := ParseRDAPResponseForNameserver(result)
+			if err == nil {
+				rdap.Data = data
+			}
+		} else if rdap.Type == "entity" {
+			data, err := ParseRDAPResponseForEntity(result)
+			if err == nil {
+				rdap.Data = data
+			}
 		} else {
 			data, err := ParseRDAPResponseforIP(result)
 			if err == nil {
@@ -244,3 +255,255 @@ func ParseRDAPResponseforASN(result map[string]interface{}) (ASNInfo, error) {
 	}
 	return asninfo, nil
 }
+
+// ParseRDAPResponseForEntity function is used to parse the RDAP response for an entity.
+func ParseRDAPResponseForEntity(result map[string]interface{}) (EntityInfo, error) {
+	entityInfo := EntityInfo{}
+
+	if handle, ok := result["handle"].(string); ok {
+		entityInfo.Handle = handle
+	}
+
+	if port43, ok := result["port43"].(string); ok {
+		entityInfo.Port43 = port43
+	}
+
+	entityInfo.Roles = toStringSlice(result["roles"])
+	entityInfo.Status = toStringSlice(result["status"])
+
+	// vCard的每一项为 [name, params, type, value]
+	for _, item := range vcardItems(result) {
+		if len(item) < 4 {
+			continue
+		}
+		name, _ := item[0].(string)
+		value, _ := item[3].(string)
+		switch name {
+		case "kind":
+			entityInfo.Kind = value
+		case "fn":
+			entityInfo.Name = value
+		case "org":
+			entityInfo.Organization = value
+		case "email":
+			if value != "" {
+				entityInfo.Emails = append(entityInfo.Emails, value)
+			}
+		case "tel":
+			if value != "" {
+				entityInfo.Phones = append(entityInfo.Phones, strings.TrimPrefix(value, "tel:"))
+			}
+		case "adr":
+			if entityInfo.Address == "" {
+				entityInfo.Address = vcardAddress(item)
+			}
+		}
+	}
+
+	if events, ok := result["events"].([]interface{}); ok {
+		for _, event := range events {
+			eventInfo, ok := event.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			date, _ := eventInfo["eventDate"].(string)
+			switch eventInfo["eventAction"] {
+			case "registration":
+				entityInfo.CreatedDate = date
+			case "last changed":
+				entityInfo.UpdatedDate = date
+			}
+		}
+	}
+
+	return entityInfo, nil
+}
+
+// ParseRDAPResponseForNameserver function is used to parse the RDAP response for a nameserver.
+func ParseRDAPResponseForNameserver(result map[string]interface{}) (NameserverInfo, error) {
+	nameserverInfo := NameserverInfo{}
+
+	if handle, ok := result["handle"].(string); ok {
+		nameserverInfo.Handle = handle
+	}
+
+	if ldhName, ok := result["ldhName"].(string); ok {
+		nameserverInfo.Name = ldhName
+	}
+
+	if unicodeName, ok := result["unicodeName"].(string); ok {
+		nameserverInfo.UnicodeName = unicodeName
+	}
+
+	if port43, ok := result["port43"].(string); ok {
+		nameserverInfo.Port43 = port43
+	}
+
+	if ipAddresses, ok := result["ipAddresses"].(map[string]interface{}); ok {
+		nameserverInfo.IPv4 = toStringSlice(ipAddresses["v4"])
+		nameserverInfo.IPv6 = toStringSlice(ipAddresses["v6"])
+	}
+
+	nameserverInfo.Status = toStringSlice(result["status"])
+	nameserverInfo.Events = parseEvents(result)
+
+	return nameserverInfo, nil
+}
+
+// ParseRDAPResponseForRegistrar function is used to parse the RDAP response for a registrar entity.
+func ParseRDAPResponseForRegistrar(result map[string]interface{}) (RegistrarInfo, error) {
+	entityInfo, err := ParseRDAPResponseForEntity(result)
+	if err != nil {
+		return RegistrarInfo{}, err
+	}
+
+	registrarInfo := RegistrarInfo{
+		Handle:       entityInfo.Handle,
+		Name:         entityInfo.Name,
+		Organization: entityInfo.Organization,
+		Emails:       entityInfo.Emails,
+		Phones:       entityInfo.Phones,
+		Address:      entityInfo.Address,
+		Status:       entityInfo.Status,
+		Port43:       entityInfo.Port43,
+		Events:       parseEvents(result),
+	}
+
+	if publicIds, ok := result["publicIds"].([]interface{}); ok {
+		for _, publicID := range publicIds {
+			id, ok := publicID.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if idType, _ := id["type"].(string); strings.EqualFold(idType, "IANA Registrar ID") {
+				registrarInfo.IANAID, _ = id["identifier"].(string)
+				break
+			}
+		}
+	}
+
+	for _, item := range vcardItems(result) {
+		if len(item) < 4 {
+			continue
+		}
+		if name, _ := item[0].(string); name == "url" {
+			registrarInfo.URL, _ = item[3].(string)
+			break
+		}
+	}
+
+	// 注册商的滥用联系人在嵌套的entity中
+	if entities, ok := result["entities"].([]interface{}); ok {
+		for _, entity := range entities {
+			contact, ok := entity.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			isAbuse := false
+			for _, role := range toStringSlice(contact["roles"]) {
+				if role == "abuse" {
+					isAbuse = true
+					break
+				}
+			}
+			if !isAbuse {
+				continue
+			}
+			abuse, _ := ParseRDAPResponseForEntity(contact)
+			if len(abuse.Emails) > 0 {
+				registrarInfo.AbuseEmail = abuse.Emails[0]
+			}
+			if len(abuse.Phones) > 0 {
+				registrarInfo.AbusePhone = abuse.Phones[0]
+			}
+			break
+		}
+	}
+
+	return registrarInfo, nil
+}
+
+// parseEvents returns the events of the RDAP object
+func parseEvents(result map[string]interface{}) []EventInfo {
+	events, ok := result["events"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	results := make([]EventInfo, 0, len(events))
+	for _, event := range events {
+		eventInfo, ok := event.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		action, _ := eventInfo["eventAction"].(string)
+		actor, _ := eventInfo["eventActor"].(string)
+		date, _ := eventInfo["eventDate"].(string)
+		results = append(results, EventInfo{Action: action, Actor: actor, Date: date})
+	}
+	return results
+}
+
+// vcardItems returns the items of the vcardArray of the RDAP object
+func vcardItems(result map[string]interface{}) [][]interface{} {
+	vcardArray, ok := result["vcardArray"].([]interface{})
+	if !ok || len(vcardArray) < 2 {
+		return nil
+	}
+
+	items, ok := vcardArray[1].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	results := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		if fields, ok := item.([]interface{}); ok {
+			results = append(results, fields)
+		}
+	}
+	return results
+}
+
+// vcardAddress returns the address of the vCard adr item, the label parameter is preferred
+func vcardAddress(item []interface{}) string {
+	if params, ok := item[1].(map[string]interface{}); ok {
+		if label, ok := params["label"].(string); ok && label != "" {
+			lines := strings.FieldsFunc(label, func(r rune) bool {
+				return r == '\n' || r == '\r'
+			})
+			for i, line := range lines {
+				lines[i] = strings.TrimSpace(line)
+			}
+			return strings.Join(lines, ", ")
+		}
+	}
+
+	values, ok := item[3].([]interface{})
+	if !ok {
+		return ""
+	}
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if text, ok := value.(string); ok && strings.TrimSpace(text) != "" {
+			parts = append(parts, strings.TrimSpace(text))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// toStringSlice returns the strings of the json array
+func toStringSlice(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	results := make([]string, 0, len(items))
+	for _, item := range items {
+		if text, ok := item.(string); ok && text != "" {
+			results = append(results, text)
+		}
+	}
+	return results
+}
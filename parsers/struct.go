@@ -84,6 +84,58 @@ type ASNInfo struct {
 	UpdatedDate  string   `json:"Updated Date"`  // UpdatedDate is the updated date of the ASN.
 }
 
+// EntityInfo represents the information about an RDAP entity, such as a contact or an organization.
+type EntityInfo struct {
+	Handle       string   `json:"handle"`                 // Handle is the registry unique identifier of the entity.
+	Kind         string   `json:"kind,omitempty"`         // Kind is the vCard kind, such as individual, org or group.
+	Name         string   `json:"name,omitempty"`         // Name is the formatted name of the entity.
+	Organization string   `json:"organization,omitempty"` // Organization is the organization of the entity.
+	Emails       []string `json:"emails,omitempty"`       // Emails is the email addresses of the entity.
+	Phones       []string `json:"phones,omitempty"`       // Phones is the phone numbers of the entity.
+	Address      string   `json:"address,omitempty"`      // Address is the postal address of the entity.
+	Roles        []string `json:"roles,omitempty"`        // Roles is the roles of the entity.
+	Status       []string `json:"status,omitempty"`       // Status is the status of the entity.
+	Port43       string   `json:"port43,omitempty"`       // Port43 is the whois server of the entity.
+	CreatedDate  string   `json:"created_date,omitempty"` // CreatedDate is the registration date of the entity.
+	UpdatedDate  string   `json:"updated_date,omitempty"` // UpdatedDate is the last changed date of the entity.
+}
+
+// EventInfo represents an RDAP event, such as registration or last changed.
+type EventInfo struct {
+	Action string `json:"action"`          // Action is the event action.
+	Actor  string `json:"actor,omitempty"` // Actor is the handle of the entity responsible for the event.
+	Date   string `json:"date"`            // Date is the time of the event.
+}
+
+// NameserverInfo represents the information about a nameserver.
+type NameserverInfo struct {
+	Handle      string      `json:"handle,omitempty"`       // Handle is the registry unique identifier of the nameserver.
+	Name        string      `json:"name"`                   // Name is the LDH name of the nameserver.
+	UnicodeName string      `json:"unicode_name,omitempty"` // UnicodeName is the unicode name of the nameserver.
+	IPv4        []string    `json:"ipv4,omitempty"`         // IPv4 is the IPv4 addresses of the nameserver.
+	IPv6        []string    `json:"ipv6,omitempty"`         // IPv6 is the IPv6 addresses of the nameserver.
+	Status      []string    `json:"status,omitempty"`       // Status is the status of the nameserver.
+	Events      []EventInfo `json:"events,omitempty"`       // Events is the events of the nameserver.
+	Port43      string      `json:"port43,omitempty"`       // Port43 is the whois server of the nameserver.
+}
+
+// RegistrarInfo represents the information about a registrar entity.
+type RegistrarInfo struct {
+	Handle       string      `json:"handle"`                 // Handle is the registry unique identifier of the registrar.
+	IANAID       string      `json:"iana_id,omitempty"`      // IANAID is the IANA ID of the registrar.
+	Name         string      `json:"name,omitempty"`         // Name is the formatted name of the registrar.
+	Organization string      `json:"organization,omitempty"` // Organization is the organization of the registrar.
+	URL          string      `json:"url,omitempty"`          // URL is the website of the registrar.
+	Emails       []string    `json:"emails,omitempty"`       // Emails is the email addresses of the registrar.
+	Phones       []string    `json:"phones,omitempty"`       // Phones is the phone numbers of the registrar.
+	Address      string      `json:"address,omitempty"`      // Address is the postal address of the registrar.
+	AbuseEmail   string      `json:"abuse_email,omitempty"`  // AbuseEmail is the email of the abuse contact.
+	AbusePhone   string      `json:"abuse_phone,omitempty"`  // AbusePhone is the phone of the abuse contact.
+	Status       []string    `json:"status,omitempty"`       // Status is the status of the registrar.
+	Events       []EventInfo `json:"events,omitempty"`       // Events is the events of the registrar.
+	Port43       string      `json:"port43,omitempty"`       // Port43 is the whois server of the registrar.
+}
+
 // IPInfo represents the information about an IP network.
 type IPInfo struct {
 	IP           string   `json:"IP Network"`    // IP is the IP network.
@@ -2,30 +2,61 @@ package whois
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/darkqiank/whois/parsers"
 )
 
 var (
-	rdapMapInstance *RdapMap
-	onceRDAP        sync.Once
+	// rdapMapInstance 是默认的RDAP配置，查询时无锁读取
+	rdapMapInstance atomic.Pointer[RdapMap]
+	// rdapMapInited 标记InitRDAP已被显式调用
+	rdapMapInited bool
+	// rdapMapMu 保护默认配置的加载和InitRDAP
+	rdapMapMu sync.Mutex
 )
 
 const (
 	defaultRDAPTimeout = 10 * time.Second
 )
 
+// defaultRDAPHTTPClient is the http client shared by the clients created by NewRDAPClient
+var defaultRDAPHTTPClient = &http.Client{
+	Timeout: defaultRDAPTimeout,
+}
+
+// rdapObject is the object class of a RDAP query, which decides how the server is found
+type rdapObject string
+
+const (
+	// rdapObjectAuto is a domain, ip or asn query, routed by the type of the query
+	rdapObjectAuto rdapObject = ""
+	// rdapObjectEntity is an entity handle query, routed by the service provider tag
+	rdapObjectEntity rdapObject = "entity"
+	// rdapObjectNameserver is a nameserver query, routed by the TLD of the nameserver
+	rdapObjectNameserver rdapObject = "nameserver"
+	// rdapObjectRegistrar is a registrar entity query by IANA ID, routed by the TLD of the registry
+	rdapObjectRegistrar rdapObject = "registrar"
+)
+
 // RDAPClient is RDAP client
 type RDAPClient struct {
 	httpClient      *http.Client
 	timeout         time.Duration
 	disableReferral bool
 	rdapMap         *RdapMap
+	cache           Cache
+	cacheTTL        CacheTTL
+	logger          Logger
 }
 
 // DefaultRDAPClient is default RDAP client
@@ -36,16 +67,24 @@ func RDAP(domain string) (result map[string]interface{}, err error) {
 	return DefaultRDAPClient.RDAP(domain)
 }
 
-// NewRDAPClient returns new RDAP client
-func NewRDAPClient() *RDAPClient {
-	return &RDAPClient{
-		httpClient: &http.Client{
-			Timeout: defaultRDAPTimeout,
-		},
+// RDAPContext do the RDAP query with context and returns RDAP information
+func RDAPContext(ctx context.Context, domain string) (result map[string]interface{}, err error) {
+	return DefaultRDAPClient.RDAPContext(ctx, domain)
+}
+
+// NewRDAPClient returns new RDAP client, the default RDAP map is used if WithRDAPMap is not set
+func NewRDAPClient(opts ...RDAPClientOption) *RDAPClient {
+	c := &RDAPClient{
+		httpClient:      defaultRDAPHTTPClient,
 		timeout:         defaultRDAPTimeout,
-		rdapMap:         rdapMapInstance,
 		disableReferral: true,
+		cacheTTL:        DefaultCacheTTL(),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 // SetDisableReferral if set to true, will not query the referral server.
@@ -54,41 +93,251 @@ func (c *RDAPClient) SetDisableReferral(disabled bool) *RDAPClient {
 	return c
 }
 
+// SetLogger set the logger of the queries, the package logger is used if nil
+func (c *RDAPClient) SetLogger(logger Logger) *RDAPClient {
+	c.logger = logger
+	return c
+}
+
+// log returns the logger of the client, the package logger if not set
+func (c *RDAPClient) log() Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+
+	return getLogger()
+}
+
+// RDAP do the RDAP query and returns RDAP information
 func (c *RDAPClient) RDAP(q string) (map[string]interface{}, error) {
+	return c.RDAPContext(context.Background(), q)
+}
+
+// RDAPContext do the RDAP query and returns RDAP information,
+// the ctx cancels the RDAP request and the referral request
+func (c *RDAPClient) RDAPContext(ctx context.Context, q string) (map[string]interface{}, error) {
+	r, err := c.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Data, nil
+}
+
+// Query do the RDAP query and returns the structured result
+func (c *RDAPClient) Query(q string) (*RDAPResult, error) {
+	return c.QueryContext(context.Background(), q)
+}
+
+// QueryContext do the RDAP query and returns the structured result,
+// the result is returned even if err is not nil
+func (c *RDAPClient) QueryContext(ctx context.Context, q string) (*RDAPResult, error) {
+	return c.queryObject(ctx, rdapObjectAuto, q, "")
+}
+
+// Entity do the RDAP query of the entity handle and returns the parsed entity,
+// the server is found by the service provider tag of the handle, such as ARIN for ABUSE7610-ARIN
+func (c *RDAPClient) Entity(handle string) (parsers.EntityInfo, error) {
+	return c.EntityContext(context.Background(), handle)
+}
+
+// EntityContext do the RDAP query of the entity handle with context and returns the parsed entity
+func (c *RDAPClient) EntityContext(ctx context.Context, handle string) (parsers.EntityInfo, error) {
+	r, err := c.queryObject(ctx, rdapObjectEntity, handle, "")
+	if err != nil {
+		return parsers.EntityInfo{}, err
+	}
+
+	return parsers.ParseRDAPResponseForEntity(r.Data)
+}
+
+// Nameserver do the RDAP query of the nameserver and returns the parsed nameserver,
+// the server is found by the TLD of the nameserver
+func (c *RDAPClient) Nameserver(name string) (parsers.NameserverInfo, error) {
+	return c.NameserverContext(context.Background(), name)
+}
+
+// NameserverContext do the RDAP query of the nameserver with context and returns the parsed nameserver
+func (c *RDAPClient) NameserverContext(ctx context.Context, name string) (parsers.NameserverInfo, error) {
+	r, err := c.QueryNameserverContext(ctx, name)
+	if err != nil {
+		return parsers.NameserverInfo{}, err
+	}
+
+	return parsers.ParseRDAPResponseForNameserver(r.Data)
+}
+
+// QueryNameserverContext do the RDAP query of the nameserver and returns the structured result,
+// the result is returned even if err is not nil
+func (c *RDAPClient) QueryNameserverContext(ctx context.Context, name string) (*RDAPResult, error) {
+	return c.queryObject(ctx, rdapObjectNameserver, strings.TrimSuffix(strings.TrimSpace(name), "."), "")
+}
+
+// Registrar do the RDAP query of the registrar entity by IANA ID and returns the parsed registrar,
+// the registrar is queried from the registry of the TLD, com if the TLD is empty
+func (c *RDAPClient) Registrar(ianaID, tld string) (parsers.RegistrarInfo, error) {
+	return c.RegistrarContext(context.Background(), ianaID, tld)
+}
+
+// RegistrarContext do the RDAP query of the registrar entity with context and returns the parsed registrar
+func (c *RDAPClient) RegistrarContext(ctx context.Context, ianaID, tld string) (parsers.RegistrarInfo, error) {
+	r, err := c.QueryRegistrarContext(ctx, ianaID, tld)
+	if err != nil {
+		return parsers.RegistrarInfo{}, err
+	}
+
+	return parsers.ParseRDAPResponseForRegistrar(r.Data)
+}
+
+// QueryRegistrarContext do the RDAP query of the registrar entity by IANA ID and returns the structured result,
+// the result is returned even if err is not nil
+func (c *RDAPClient) QueryRegistrarContext(ctx context.Context, ianaID, tld string) (*RDAPResult, error) {
+	return c.queryObject(ctx, rdapObjectRegistrar, ianaID, registrarTLD(tld))
+}
+
+// queryObject do the RDAP query of the object class and returns the structured result,
+// tld is the registry of the registrar query, the result is returned even if err is not nil
+func (c *RDAPClient) queryObject(ctx context.Context, object rdapObject, q, tld string) (*RDAPResult, error) {
+	result := &RDAPResult{StartTime: time.Now()}
+	defer func() {
+		result.Duration = time.Since(result.StartTime)
+	}()
+
+	q = strings.TrimSpace(q)
+	result.Query = q
 	if q == "" {
-		return nil, ErrDomainEmpty
-	}
-	_, url, exists := rdapMapInstance.GetRdapServer(q)
-	fmt.Println(url)
-	if exists {
-		res, err := c.rdapRawQuery(url)
-		if res != nil && err == nil {
-			if !c.disableReferral {
-				// 配置了不跳过 refer，域名/IP/ASN 都允许继续跟随 related 链接
-				refURL, exists := GetRelURL(res)
-				if exists && refURL != "" && refURL != url {
-					res, err = c.rdapRawQuery(refURL)
-				}
+		return result, ErrDomainEmpty
+	}
+
+	// 域名、IP和ASN查询保持原有的缓存key
+	key := fmt.Sprintf("rdap:%s#%t", strings.ToLower(q), !c.disableReferral)
+	if object == rdapObjectRegistrar {
+		key = fmt.Sprintf("rdap:%s:%s@%s#%t", object, strings.ToLower(q), tld, !c.disableReferral)
+	} else if object != rdapObjectAuto {
+		key = fmt.Sprintf("rdap:%s:%s#%t", object, strings.ToLower(q), !c.disableReferral)
+	}
+	// 使用自己的RDAP配置的客户端不与其他配置共享缓存
+	if c.rdapMap != nil {
+		key += fmt.Sprintf("|%p", c.rdapMap)
+	}
+	if c.cache != nil {
+		if v, ok := c.cache.Get(key); ok {
+			if entry, ok := v.(*rdapCacheEntry); ok {
+				start := result.StartTime
+				*result = *entry.result.clone()
+				result.StartTime, result.Cache = start, CacheHit
+				return result, entry.err
 			}
-			return res, err
+		}
+	}
+
+	// 并发的相同查询只发起一次，共享查询结果，不同的服务器配置和http客户端不共享
+	v, err, shared := lookupGroup.do(ctx, c.flightKey(key), func(ctx context.Context) (interface{}, error) {
+		r := &RDAPResult{Query: q, StartTime: time.Now()}
+		err := c.lookup(ctx, r, object, q, tld)
+		r.Duration = time.Since(r.StartTime)
+		if err != nil {
+			c.log().Warn("rdap lookup failed", "query", q, "object", object, "url", r.URL, "duration", r.Duration, "error", err)
 		} else {
-			return nil, fmt.Errorf("rdap: query rdap server (%s) failed: (%s)", url, err)
+			c.log().Debug("rdap lookup", "query", q, "object", object, "url", r.URL, "duration", r.Duration)
+		}
+		// 被取消的查询不缓存
+		if c.cache != nil && ctx.Err() == nil {
+			ttl := c.cacheTTL.ttlOf(errors.Is(err, ErrRDAPNotFound), err)
+			c.cache.Set(key, &rdapCacheEntry{result: r.clone(), err: err}, ttl)
+		}
+		return r, err
+	})
+	if v == nil {
+		return result, err
+	}
+
+	start := result.StartTime
+	*result = *v.(*RDAPResult).clone()
+	result.StartTime, result.Shared = start, shared
+	if c.cache != nil {
+		result.Cache = CacheMiss
+	}
+
+	return result, err
+}
+
+// lookup do the RDAP query and the referral query, sets the url and data of the result
+func (c *RDAPClient) lookup(ctx context.Context, result *RDAPResult, object rdapObject, q, tld string) error {
+	url, err := c.objectURL(object, q, tld)
+	if err != nil {
+		return err
+	}
+
+	result.URL = url
+	res, err := c.rdapRawQuery(ctx, url)
+	if res == nil || err != nil {
+		return fmt.Errorf("rdap: query rdap server (%s) failed: (%w)", url, err)
+	}
+
+	if !c.disableReferral {
+		// 配置了不跳过 refer，域名/IP/ASN 都允许继续跟随 related 链接
+		refURL, exists := GetRelURL(res)
+		if exists && refURL != "" && refURL != url {
+			result.URL = refURL
+			res, err = c.rdapRawQuery(ctx, refURL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	result.Data = res
+
+	return nil
+}
+
+// objectURL returns the RDAP url of the query of the object class
+func (c *RDAPClient) objectURL(object rdapObject, q, tld string) (string, error) {
+	switch object {
+	case rdapObjectNameserver:
+		url, exists := c.servers().GetNameserverServer(q)
+		if !exists {
+			return "", fmt.Errorf("%w for nameserver %s", ErrRDAPServerNotFound, q)
+		}
+		return url, nil
+	case rdapObjectRegistrar:
+		url, exists := c.servers().GetRegistrarServer(q, tld)
+		if !exists {
+			return "", fmt.Errorf("%w for registrar %s of %s", ErrRDAPServerNotFound, q, tld)
 		}
+		return url, nil
+	case rdapObjectEntity:
+		url, exists := c.servers().GetEntityServer(q)
+		if !exists {
+			return "", fmt.Errorf("%w for entity %s", ErrRDAPServerNotFound, q)
+		}
+		return url, nil
+	default:
+		_, url, exists := c.servers().GetRdapServer(q)
+		if !exists {
+			return "", fmt.Errorf("%w for %s", ErrRDAPServerNotFound, q)
+		}
+		return url, nil
 	}
-	return nil, fmt.Errorf("rdap: query rdap server (%s) failed", url)
 }
 
 // 查询rdap
-func (c *RDAPClient) rdapRawQuery(url string) (map[string]interface{}, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func (c *RDAPClient) rdapRawQuery(ctx context.Context, url string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		c.log().Debug("rdap request", "url", url, "duration", time.Since(start), "error", err)
 		return nil, err
 	}
+	c.log().Debug("rdap request", "url", url, "status", resp.StatusCode, "duration", time.Since(start))
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
@@ -97,7 +346,7 @@ func (c *RDAPClient) rdapRawQuery(url string) (map[string]interface{}, error) {
 	}(resp.Body)
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, errors.New("resource not found")
+		return nil, ErrRDAPNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -107,7 +356,6 @@ func (c *RDAPClient) rdapRawQuery(url string) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	// fmt.Println(buf.String())
 	//尝试解析json
 	var result map[string]interface{}
 	err = json.Unmarshal(buf.Bytes(), &result)
@@ -143,23 +391,70 @@ func GetRelURL(res map[string]interface{}) (string, bool) {
 	return "", false
 }
 
-// InitRDAP sync.Once的作用是确保在多线程环境下一个操作只被执行一次
+// servers returns the RDAP map of the client, the default RDAP map if not set
+func (c *RDAPClient) servers() *RdapMap {
+	if c.rdapMap != nil {
+		return c.rdapMap
+	}
+
+	return defaultRdapMap()
+}
+
+// defaultRdapMap returns the RDAP map loaded by InitRDAP, the embedded config is loaded if not initialized
+func defaultRdapMap() *RdapMap {
+	if rm := rdapMapInstance.Load(); rm != nil {
+		return rm
+	}
+
+	rdapMapMu.Lock()
+	defer rdapMapMu.Unlock()
+
+	rm := rdapMapInstance.Load()
+	if rm == nil {
+		rm = NewRdapMap()
+		if err := rm.LoadFromFile(""); err != nil {
+			panic(err)
+		}
+		rdapMapInstance.Store(rm)
+	}
+
+	return rm
+}
+
+// InitRDAP loads the default RDAP map from the config file, the embedded config is used if empty,
+// "online" or a base url such as https://data.iana.org/rdap/ fetches the bootstrap files,
+// the embedded config is used if the fetch fails. only the first call takes effect,
+// it replaces the embedded config loaded by the queries before it. it panics if the config file is invalid.
 func InitRDAP(configFile string) {
-	onceRDAP.Do(func() {
-		var err error
-		rdapMapInstance = NewRdapMap()
-		if configFile == "online" {
-			err = rdapMapInstance.LoadFromIANA()
-			if err != nil {
-				err = rdapMapInstance.LoadFromFile(configFile)
-			}
+	rdapMapMu.Lock()
+	defer rdapMapMu.Unlock()
+
+	if rdapMapInited {
+		return
+	}
+	rdapMapInited = true
+
+	// 已被查询懒加载内置配置时在原map上替换配置
+	rm := rdapMapInstance.Load()
+	if rm == nil {
+		rm = NewRdapMap()
+	}
+	var err error
+	if isRemoteRDAPSource(configFile) {
+		var config *RdapConfig
+		config, err = loadRdapConfig(configFile)
+		if err == nil {
+			rm.setConfig(config, configFile)
 		} else {
-			err = rdapMapInstance.LoadFromFile(configFile)
+			// 获取失败时使用内置的配置
+			getLogger().Warn("rdap bootstrap fetch failed, use the embedded config", "source", configFile, "error", err)
+			err = rm.LoadFromFile("")
 		}
-		if err != nil {
-			// 统一的错误处理
-			// 可以选择记录日志、发送报警、panic等
-			panic(err)
-		}
-	})
+	} else {
+		err = rm.LoadFromFile(configFile)
+	}
+	if err != nil {
+		panic(err)
+	}
+	rdapMapInstance.Store(rm)
 }
@@ -0,0 +1,374 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRDAPBootstrapURL is the base url of the IANA RDAP bootstrap files
+	DefaultRDAPBootstrapURL = "https://data.iana.org/rdap/"
+	// defaultBootstrapRefreshInterval is the default interval to refresh the bootstrap files
+	defaultBootstrapRefreshInterval = 24 * time.Hour
+	// defaultBootstrapRetryInterval is the default first retry delay after the fetch fails
+	defaultBootstrapRetryInterval = time.Minute
+	// defaultBootstrapTimeout is the default timeout to fetch a bootstrap file
+	defaultBootstrapTimeout = 20 * time.Second
+)
+
+// BootstrapSource is where the loaded RDAP bootstrap comes from
+type BootstrapSource string
+
+const (
+	// BootstrapSourceOnline is the bootstrap fetched from the base url
+	BootstrapSourceOnline BootstrapSource = "online"
+	// BootstrapSourceCache is the last good bootstrap saved on disk
+	BootstrapSourceCache BootstrapSource = "cache"
+	// BootstrapSourceEmbedded is the embedded config/rdap.json
+	BootstrapSourceEmbedded BootstrapSource = "embedded"
+)
+
+// BootstrapRefreshOptions is the options of the RDAP bootstrap refresh
+type BootstrapRefreshOptions struct {
+	// BaseURL is the base url of the dns, ipv4, ipv6, asn and the optional object-tags json files, DefaultRDAPBootstrapURL if empty
+	BaseURL string
+	// Interval is the refresh interval, 24h if not greater than 0
+	Interval time.Duration
+	// RetryInterval is the first retry delay when the fetch fails or the fallback is loaded,
+	// it is doubled after every failure up to Interval, 1m if not greater than 0
+	RetryInterval time.Duration
+	// CacheFile is the file to keep the last good bootstrap, which is loaded if the fetch fails, empty to disable
+	CacheFile string
+	// HTTPClient is used to fetch the bootstrap files, a client with 20s timeout if nil
+	HTTPClient *http.Client
+}
+
+// BootstrapStatus is the refresh status of the RDAP bootstrap
+type BootstrapStatus struct {
+	// Source is where the loaded bootstrap comes from
+	Source BootstrapSource `json:"source"`
+	// BaseURL is the base url of the bootstrap files
+	BaseURL string `json:"base_url"`
+	// Publications is the publication time of the loaded files, keyed by dns, ipv4, ipv6, asn and object-tags
+	Publications map[string]string `json:"publications"`
+	// LastRefresh is the time of the last refresh attempt
+	LastRefresh time.Time `json:"last_refresh,omitempty"`
+	// LastSuccess is the time of the last successful fetch
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	// LastChange is the time the bootstrap with a new publication is loaded
+	LastChange time.Time `json:"last_change,omitempty"`
+	// LastError is the error of the last refresh, empty if succeeded
+	LastError string `json:"last_error,omitempty"`
+	// NextRefresh is the time of the next refresh, zero if not running
+	NextRefresh time.Time `json:"next_refresh,omitempty"`
+}
+
+// BootstrapRefresher loads the RDAP map from the bootstrap files and refreshes it periodically,
+// the bootstrap is replaced only if the publication of any file is changed
+type BootstrapRefresher struct {
+	rm            *RdapMap
+	baseURL       string
+	interval      time.Duration
+	retryInterval time.Duration
+	cacheFile     string
+	client        *http.Client
+
+	mu     sync.Mutex
+	status BootstrapStatus
+	// objectTags 是最后加载的object tags，镜像没有object-tags文件时继续使用
+	objectTags RDAPData
+}
+
+var (
+	// defaultRefresher is the refresher of the default RDAP map
+	defaultRefresher   *BootstrapRefresher
+	defaultRefresherMu sync.Mutex
+)
+
+// NewBootstrapRefresher returns a refresher of the RDAP map
+func NewBootstrapRefresher(rm *RdapMap, opts BootstrapRefreshOptions) *BootstrapRefresher {
+	r := &BootstrapRefresher{
+		rm:            rm,
+		baseURL:       opts.BaseURL,
+		interval:      opts.Interval,
+		retryInterval: opts.RetryInterval,
+		cacheFile:     opts.CacheFile,
+		client:        opts.HTTPClient,
+	}
+	if r.baseURL == "" {
+		r.baseURL = DefaultRDAPBootstrapURL
+	}
+	if r.interval <= 0 {
+		r.interval = defaultBootstrapRefreshInterval
+	}
+	if r.retryInterval <= 0 {
+		r.retryInterval = defaultBootstrapRetryInterval
+	}
+	if r.retryInterval > r.interval {
+		r.retryInterval = r.interval
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: defaultBootstrapTimeout}
+	}
+	r.status.BaseURL = r.baseURL
+
+	return r
+}
+
+// EnableRDAPBootstrapRefresh loads the default RDAP map from the bootstrap files,
+// and refreshes it every interval until ctx is done. if the fetch fails,
+// the last good bootstrap in the cache file or the embedded config is loaded.
+func EnableRDAPBootstrapRefresh(ctx context.Context, opts BootstrapRefreshOptions) *BootstrapRefresher {
+	r := NewBootstrapRefresher(defaultRdapMap(), opts)
+	_ = r.Load(ctx)
+
+	defaultRefresherMu.Lock()
+	defaultRefresher = r
+	defaultRefresherMu.Unlock()
+
+	go r.Run(ctx)
+
+	return r
+}
+
+// RDAPBootstrapStatus returns the refresh status of the default RDAP map,
+// false if EnableRDAPBootstrapRefresh is not called
+func RDAPBootstrapStatus() (BootstrapStatus, bool) {
+	r := defaultBootstrapRefresher()
+	if r == nil {
+		return BootstrapStatus{}, false
+	}
+
+	return r.Status(), true
+}
+
+// defaultBootstrapRefresher returns the refresher of the default RDAP map, nil if not enabled
+func defaultBootstrapRefresher() *BootstrapRefresher {
+	defaultRefresherMu.Lock()
+	defer defaultRefresherMu.Unlock()
+
+	return defaultRefresher
+}
+
+// Load fetches and loads the bootstrap, if the fetch fails, the cache file is loaded,
+// and then the embedded config. the fetch error is returned even if a fallback is loaded.
+func (r *BootstrapRefresher) Load(ctx context.Context) error {
+	_, err := r.Refresh(ctx)
+	if err == nil {
+		return nil
+	}
+
+	if r.cacheFile != "" {
+		bootstrap, cacheErr := readRDAPBootstrapCache(r.cacheFile)
+		if cacheErr == nil {
+			cacheErr = r.apply(bootstrap, r.cacheFile, BootstrapSourceCache)
+		}
+		if cacheErr == nil {
+			getLogger().Warn("rdap bootstrap fetch failed, use the cache file", "url", r.baseURL,
+				"file", r.cacheFile, "error", err)
+			return err
+		}
+		getLogger().Warn("rdap bootstrap cache file load failed", "file", r.cacheFile, "error", cacheErr)
+	}
+
+	bootstrap, embeddedErr := readRDAPBootstrap("")
+	if embeddedErr == nil {
+		embeddedErr = r.apply(bootstrap, "", BootstrapSourceEmbedded)
+	}
+	if embeddedErr != nil {
+		return embeddedErr
+	}
+	getLogger().Warn("rdap bootstrap fetch failed, use the embedded config", "url", r.baseURL, "error", err)
+
+	return err
+}
+
+// Refresh fetches the bootstrap files and loads them if the publication of any file is changed,
+// the current bootstrap is kept on error
+func (r *BootstrapRefresher) Refresh(ctx context.Context) (changed bool, err error) {
+	now := time.Now()
+	defer func() {
+		r.mu.Lock()
+		r.status.LastRefresh = now
+		if err != nil {
+			r.status.LastError = err.Error()
+		} else {
+			r.status.LastSuccess = now
+			r.status.LastError = ""
+		}
+		r.mu.Unlock()
+	}()
+
+	r.mu.Lock()
+	objectTags := r.objectTags
+	r.mu.Unlock()
+
+	bootstrap, err := fetchRDAPBootstrap(ctx, r.client, r.baseURL, objectTags)
+	if err != nil {
+		return false, err
+	}
+	if len(bootstrap.DNS.Services) == 0 {
+		return false, fmt.Errorf("rdap: bootstrap of %s has no dns services", r.baseURL)
+	}
+
+	r.mu.Lock()
+	current := r.status.Publications
+	source := r.status.Source
+	r.mu.Unlock()
+
+	publications := bootstrapPublications(bootstrap)
+	if source == BootstrapSourceOnline && equalPublications(current, publications) {
+		getLogger().Debug("rdap bootstrap not changed", "url", r.baseURL)
+		return false, nil
+	}
+
+	if err := r.apply(bootstrap, r.baseURL, BootstrapSourceOnline); err != nil {
+		return false, err
+	}
+
+	// 保存最后一次有效的bootstrap，下次获取失败时使用
+	if r.cacheFile != "" {
+		if err := writeRDAPBootstrapCache(r.cacheFile, bootstrap); err != nil {
+			getLogger().Warn("save rdap bootstrap cache file failed", "file", r.cacheFile, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
+// Run refreshes the bootstrap every interval until ctx is done, while the fetch fails or the fallback is loaded,
+// it retries with a backoff from the retry interval up to the interval
+func (r *BootstrapRefresher) Run(ctx context.Context) {
+	retry := r.retryInterval
+	for {
+		delay := r.interval
+		if r.degraded() {
+			// 使用缓存或内置配置时尽快重试，避免长时间使用过期的bootstrap
+			delay, retry = retry, retry*2
+			if retry > r.interval {
+				retry = r.interval
+			}
+		} else {
+			retry = r.retryInterval
+		}
+
+		r.mu.Lock()
+		r.status.NextRefresh = time.Now().Add(delay)
+		r.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.mu.Lock()
+			r.status.NextRefresh = time.Time{}
+			r.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		changed, err := r.Refresh(ctx)
+		if err != nil {
+			getLogger().Warn("rdap bootstrap refresh failed, keep the current bootstrap", "url", r.baseURL, "error", err)
+		} else if changed {
+			getLogger().Info("rdap bootstrap refreshed", "url", r.baseURL)
+		}
+	}
+}
+
+// degraded returns if the last fetch failed or the loaded bootstrap is not fetched online
+func (r *BootstrapRefresher) degraded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status.Source != BootstrapSourceOnline || r.status.LastError != ""
+}
+
+// Status returns the refresh status
+func (r *BootstrapRefresher) Status() BootstrapStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := r.status
+	status.Publications = make(map[string]string, len(r.status.Publications))
+	for k, v := range r.status.Publications {
+		status.Publications[k] = v
+	}
+
+	return status
+}
+
+// apply validates and loads the bootstrap to the RDAP map
+func (r *BootstrapRefresher) apply(bootstrap RDAPBootstrap, source string, from BootstrapSource) error {
+	config, err := newRdapConfig(nil, bootstrap)
+	if err != nil {
+		return err
+	}
+	r.rm.setConfig(config, source)
+
+	r.mu.Lock()
+	r.status.Source = from
+	r.status.Publications = bootstrapPublications(bootstrap)
+	r.status.LastChange = time.Now()
+	r.objectTags = bootstrap.ObjectTags
+	r.mu.Unlock()
+
+	return nil
+}
+
+// bootstrapPublications returns the publication time of the bootstrap files
+func bootstrapPublications(bootstrap RDAPBootstrap) map[string]string {
+	return map[string]string{
+		"dns":         bootstrap.DNS.Publication,
+		"ipv4":        bootstrap.IPv4.Publication,
+		"ipv6":        bootstrap.IPv6.Publication,
+		"asn":         bootstrap.ASN.Publication,
+		"object-tags": bootstrap.ObjectTags.Publication,
+	}
+}
+
+// equalPublications returns if the publication time of all the files are the same
+func equalPublications(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readRDAPBootstrapCache reads the bootstrap saved in the cache file
+func readRDAPBootstrapCache(filename string) (RDAPBootstrap, error) {
+	var bootstrap RDAPBootstrap
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return bootstrap, err
+	}
+	if err := json.Unmarshal(data, &bootstrap); err != nil {
+		return bootstrap, err
+	}
+	if len(bootstrap.DNS.Services) == 0 {
+		return bootstrap, fmt.Errorf("rdap: bootstrap cache file %s has no dns services", filename)
+	}
+
+	return bootstrap, nil
+}
+
+// writeRDAPBootstrapCache saves the bootstrap to the cache file atomically
+func writeRDAPBootstrapCache(filename string, bootstrap RDAPBootstrap) error {
+	data, err := json.Marshal(bootstrap)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, data)
+}
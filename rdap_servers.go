@@ -1,6 +1,7 @@
 package whois
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"io/fs"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
@@ -21,12 +23,20 @@ import (
 //go:embed config/rdap.json
 var embeddedRADPFiles embed.FS
 
+// maxRDAPBootstrapSize 是单个bootstrap文件的最大字节数
+const maxRDAPBootstrapSize = 8 << 20
+
+// defaultRegistrarTLD 是查询注册商时默认使用的注册局
+const defaultRegistrarTLD = "com"
+
 // RDAPBootstrap 定义一个结构体来映射原始JSON数据的最外层
 type RDAPBootstrap struct {
 	IPv4 RDAPData `json:"ipv4"`
 	IPv6 RDAPData `json:"ipv6"`
 	ASN  RDAPData `json:"asn"`
 	DNS  RDAPData `json:"dns"`
+	// ObjectTags 是RFC 8521的服务提供者对象标签，每个service有联系人、标签、URL三个数组
+	ObjectTags RDAPData `json:"object_tags,omitempty"`
 }
 
 // RDAPData 结构体用于映射IPv4、IPv6、dns、asn的数据
@@ -38,11 +48,13 @@ type RDAPData struct {
 }
 
 type RdapConfig struct {
-	IP        map[string]string `json:"ip"`
-	ASN       map[string]string `json:"asn"`
-	TLD       map[string]string `json:"tld"`
-	asnRanges []ASNRange
-	ipRanger  cidranger.Ranger
+	IP  map[string]string `json:"ip"`
+	ASN map[string]string `json:"asn"`
+	TLD map[string]string `json:"tld"`
+	// ObjectTags 以大写的服务提供者标签为key，如ARIN、RIPE
+	ObjectTags map[string]string `json:"object_tags"`
+	asnRanges  []ASNRange
+	ipRanger   cidranger.Ranger
 }
 
 // ASNRange 表示ASN范围和对应的URL
@@ -55,17 +67,28 @@ type ASNRange struct {
 type RdapMap struct {
 	sync.RWMutex
 	config *RdapConfig
+	// source 是最后加载的配置来源，文件路径、online或空（嵌入的配置），重新加载时使用
+	source string
+}
+
+// rdapSourceOnline is the config source which loads the bootstrap from IANA
+const rdapSourceOnline = "online"
+
+// isRemoteRDAPSource returns if the config source is fetched from IANA or a mirror
+func isRemoteRDAPSource(source string) bool {
+	return source == rdapSourceOnline || strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
 }
 
 // NewRdapMap creates a new rdapMap instance
 func NewRdapMap() *RdapMap {
 	return &RdapMap{
 		config: &RdapConfig{
-			IP:        make(map[string]string),
-			ASN:       make(map[string]string),
-			TLD:       make(map[string]string),
-			asnRanges: make([]ASNRange, 0),
-			ipRanger:  cidranger.NewPCTrieRanger(), // 假设使用PCTrie实现
+			IP:         make(map[string]string),
+			ASN:        make(map[string]string),
+			TLD:        make(map[string]string),
+			ObjectTags: make(map[string]string),
+			asnRanges:  make([]ASNRange, 0),
+			ipRanger:   cidranger.NewPCTrieRanger(), // 假设使用PCTrie实现
 		},
 	}
 }
@@ -77,19 +100,80 @@ func (rm *RdapMap) GetRdapServer(query string) (string, string, bool) {
 	ip := net.ParseIP(query)
 	if ip != nil {
 		url, exists := rm.findIPServer(ip)
-		println("ip")
 		return "ip", url, exists
 	} else if IsASN(query) {
-		println("asn")
 		url, exists := rm.findASNServer(query)
 		return "asn", url, exists
 	} else {
-		println("domain")
 		url, exists := rm.findTLDServer(query)
 		return "domain", url, exists
 	}
 }
 
+// GetEntityServer returns the RDAP url of the entity handle, which is routed by the service provider tag
+// after the last hyphen of the handle, such as ARIN for ABUSE7610-ARIN
+func (rm *RdapMap) GetEntityServer(handle string) (string, bool) {
+	i := strings.LastIndex(handle, "-")
+	if i <= 0 || i == len(handle)-1 {
+		return "", false
+	}
+	tag := strings.ToUpper(handle[i+1:])
+
+	rm.RLock()
+	defer rm.RUnlock()
+	server, exists := rm.config.ObjectTags[tag]
+	if !exists {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s%s/%s", server, "entity", url.PathEscape(handle)), true
+}
+
+// GetNameserverServer returns the RDAP url of the nameserver, which is routed by the TLD of the nameserver
+func (rm *RdapMap) GetNameserverServer(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" || net.ParseIP(name) != nil {
+		return "", false
+	}
+
+	rm.RLock()
+	defer rm.RUnlock()
+	server, exists := rm.config.TLD[getExtension(name)]
+	if !exists {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s%s/%s", server, "nameserver", name), true
+}
+
+// GetRegistrarServer returns the RDAP url of the registrar entity of the IANA ID,
+// which is queried from the registry of the TLD, com if the TLD is empty
+func (rm *RdapMap) GetRegistrarServer(ianaID, tld string) (string, bool) {
+	if _, err := strconv.ParseUint(ianaID, 10, 32); err != nil {
+		return "", false
+	}
+	tld = registrarTLD(tld)
+
+	rm.RLock()
+	defer rm.RUnlock()
+	server, exists := rm.config.TLD[tld]
+	if !exists {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s%s/%s", server, "entity", ianaID), true
+}
+
+// registrarTLD returns the normalized TLD of the registrar query, com if empty
+func registrarTLD(tld string) string {
+	tld = strings.Trim(strings.ToLower(strings.TrimSpace(tld)), ".")
+	if tld == "" {
+		return defaultRegistrarTLD
+	}
+
+	return tld
+}
+
 func (rm *RdapMap) findIPServer(ip net.IP) (string, bool) {
 	entries, err := rm.config.ipRanger.ContainingNetworks(ip)
 	if err == nil && len(entries) > 0 {
@@ -155,8 +239,7 @@ func NewASNRange(rangeStr, url string) (ASNRange, error) {
 
 func (rm *RdapMap) findTLDServer(query string) (string, bool) {
 	ext := getExtension(query)
-	rm.RLock()
-	defer rm.RUnlock()
+	// 调用方已持有读锁，重复加读锁在重新加载等待写锁时会死锁
 	server, exists := rm.config.TLD[ext]
 	if exists {
 		url := fmt.Sprintf("%s%s/%s", server, "domain", query)
@@ -165,125 +248,285 @@ func (rm *RdapMap) findTLDServer(query string) (string, bool) {
 	return "", false
 }
 
-// LoadFromFile loads the server map from a JSON file
+// LoadFromFile loads the server map from a JSON file, the embedded config is used if filename is empty.
+// the config is validated before replacing the current one, which is kept on error.
 func (rm *RdapMap) LoadFromFile(filename string) error {
+	bootstrap, err := readRDAPBootstrap(filename)
+	if err != nil {
+		return err
+	}
+
+	return rm.replace(bootstrap, filename)
+}
+
+// LoadFromIANA loads the server map from the IANA bootstrap files,
+// the current config is kept on error
+func (rm *RdapMap) LoadFromIANA() error {
+	bootstrap, err := fetchIANABootstrap()
+	if err != nil {
+		return err
+	}
+
+	return rm.replace(bootstrap, rdapSourceOnline)
+}
+
+// readRDAPBootstrap reads the bootstrap from the file or the embedded config if filename is empty
+func readRDAPBootstrap(filename string) (RDAPBootstrap, error) {
+	var bootstrap RDAPBootstrap
 	var data []byte
 	var err error
 
 	if filename != "" {
 		// 使用os.ReadFile读取文件
 		data, err = os.ReadFile(filename)
-		fmt.Printf("从指定路径 %s 读取配置", filename)
 		if err != nil {
-			return err
+			return bootstrap, err
 		}
 	} else {
 		// 从嵌入的文件系统读取
 		data, err = fs.ReadFile(embeddedRADPFiles, "config/rdap.json")
-		fmt.Println("从默认路径读取rdap.json")
 		if err != nil {
-			return err
+			return bootstrap, err
 		}
 	}
-	var bootstrap RDAPBootstrap
 	err = json.Unmarshal(data, &bootstrap)
-	if err != nil {
-		return err
-	}
-
-	return rm.LoadBootstrap(bootstrap)
+	return bootstrap, err
 }
 
-func (rm *RdapMap) LoadFromIANA() error {
-	var bootstrap RDAPBootstrap
+// fetchIANABootstrap fetches the bootstrap files from IANA
+func fetchIANABootstrap() (RDAPBootstrap, error) {
 	// 创建一个http.Client实例并设置超时
 	client := &http.Client{
-		Timeout: 20 * time.Second, // 例如，10秒超时
+		Timeout: 20 * time.Second,
 	}
-	baseIANAURL := "https://data.iana.org/rdap/"
-	rdapTypes := []string{"dns", "ipv4", "ipv6", "asn"}
-	fmt.Printf("从IANA官方 %s 读取配置\n", baseIANAURL)
+	return fetchRDAPBootstrap(context.Background(), client, DefaultRDAPBootstrapURL, RDAPData{})
+}
+
+// fetchRDAPBootstrap fetches the dns, ipv4, ipv6, asn and object-tags bootstrap files from the base url.
+// the object-tags file is optional, objectTags is used if it is not fetched, and the embedded ones if objectTags is empty.
+func fetchRDAPBootstrap(ctx context.Context, client *http.Client, baseURL string, objectTags RDAPData) (RDAPBootstrap, error) {
+	var bootstrap RDAPBootstrap
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	rdapTypes := []string{"dns", "ipv4", "ipv6", "asn", "object-tags"}
 	for _, rdapType := range rdapTypes {
-		url := fmt.Sprintf("%s%s%s", baseIANAURL, rdapType, ".json")
-		fmt.Printf("请求 %s \n", url)
-		resp, err := client.Get(url)
-		if err != nil {
-			fmt.Printf("请求 %s 时出错: %v\n", url, err)
-			return err
-		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
+		url := fmt.Sprintf("%s%s%s", baseURL, rdapType, ".json")
+		getLogger().Debug("fetch rdap bootstrap", "url", url)
+		data, err := fetchRDAPBootstrapFile(ctx, client, url)
+		if err == nil {
+			// 根据URL决定如何更新RDAPBootstrap实例
+			switch rdapType {
+			case "dns":
+				err = json.Unmarshal(data, &bootstrap.DNS)
+			case "ipv4":
+				err = json.Unmarshal(data, &bootstrap.IPv4)
+			case "ipv6":
+				err = json.Unmarshal(data, &bootstrap.IPv6)
+			case "asn":
+				err = json.Unmarshal(data, &bootstrap.ASN)
+			case "object-tags":
+				err = json.Unmarshal(data, &bootstrap.ObjectTags)
+			}
 			if err != nil {
-
+				err = fmt.Errorf("rdap: parse %s failed: %w", url, err)
 			}
-		}(resp.Body)
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("请求 %s 返回非200状态码: %d\n", url, resp.StatusCode)
-			return fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
 		}
-
-		data, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("读取 %s 响应时出错: %v\n", url, err)
-			return err
+		if err == nil {
+			continue
 		}
-		if err != nil {
-			fmt.Printf("读取 %s 响应时出错: %v\n", url, err)
-			return err
+		if rdapType != "object-tags" {
+			return bootstrap, err
 		}
 
-		// 根据URL决定如何更新RDAPBootstrap实例
-		switch rdapType {
-		case "dns":
-			err = json.Unmarshal(data, &bootstrap.DNS)
-		case "ipv4":
-			err = json.Unmarshal(data, &bootstrap.IPv4)
-		case "ipv6":
-			err = json.Unmarshal(data, &bootstrap.IPv6)
-		case "asn":
-			err = json.Unmarshal(data, &bootstrap.ASN)
-		}
-		if err != nil {
-			fmt.Printf("解析 %s 数据时出错: %v\n", url, err)
-			return err
+		// 镜像可能没有object-tags文件，使用之前的或内置的标签，不影响其他文件的更新
+		if len(objectTags.Services) == 0 {
+			objectTags = embeddedObjectTags()
 		}
+		bootstrap.ObjectTags = objectTags
+		getLogger().Warn("rdap object tags fetch failed, keep the previous object tags", "url", url, "error", err)
 	}
-	return rm.LoadBootstrap(bootstrap)
+	return bootstrap, nil
+}
+
+// embeddedObjectTags returns the object tags of the embedded config, empty if it is not readable
+func embeddedObjectTags() RDAPData {
+	bootstrap, err := readRDAPBootstrap("")
+	if err != nil {
+		return RDAPData{}
+	}
+
+	return bootstrap.ObjectTags
 }
 
-// LoadBootstrap loads the server map from Bootstrap
+// fetchRDAPBootstrapFile returns the body of the bootstrap file
+func fetchRDAPBootstrapFile(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rdap: fetch %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rdap: fetch %s failed: status code error: %d %s", url, resp.StatusCode, resp.Status)
+	}
+
+	// 多读一个字节判断文件是否超出限制，避免截断后的json解析错误
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRDAPBootstrapSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("rdap: read %s failed: %w", url, err)
+	}
+	if int64(len(data)) > maxRDAPBootstrapSize {
+		return nil, fmt.Errorf("rdap: bootstrap file %s too large: more than %d bytes", url, maxRDAPBootstrapSize)
+	}
+
+	return data, nil
+}
+
+// Reload reloads the config from the source loaded last time, the current config is kept on error
+func (rm *RdapMap) Reload() error {
+	source := rm.Source()
+	config, err := loadRdapConfig(source)
+	if err != nil {
+		return err
+	}
+
+	rm.setConfig(config, source)
+
+	return nil
+}
+
+// Source returns the config source loaded last time,
+// a file path, "online" for IANA, or empty for the embedded config
+func (rm *RdapMap) Source() string {
+	rm.RLock()
+	defer rm.RUnlock()
+	return rm.source
+}
+
+// LoadBootstrap merges the bootstrap into the server map,
+// the merged config is validated before replacing the current one
 func (rm *RdapMap) LoadBootstrap(bootstrap RDAPBootstrap) error {
 	rm.Lock()
 	defer rm.Unlock()
 
-	// 合并IPv4和IPv6到IP map中
-	mergeServices(bootstrap.IPv4.Services, rm.config.IP)
-	mergeServices(bootstrap.IPv6.Services, rm.config.IP)
+	config, err := newRdapConfig(rm.config, bootstrap)
+	if err != nil {
+		return err
+	}
+	rm.config = config
+
+	return nil
+}
+
+// replace replaces the server map by the bootstrap loaded from the source
+func (rm *RdapMap) replace(bootstrap RDAPBootstrap, source string) error {
+	config, err := newRdapConfig(nil, bootstrap)
+	if err != nil {
+		return err
+	}
+
+	rm.setConfig(config, source)
 
-	// ASN 和 DNS 转换
-	mergeServices(bootstrap.ASN.Services, rm.config.ASN)
-	mergeServices(bootstrap.DNS.Services, rm.config.TLD)
+	return nil
+}
+
+// setConfig replaces the config and the source
+func (rm *RdapMap) setConfig(config *RdapConfig, source string) {
+	rm.Lock()
+	rm.config = config
+	rm.source = source
+	rm.Unlock()
+
+	getLogger().Info("rdap config loaded", "source", configSourceName(source),
+		"tld", len(config.TLD), "ip", len(config.IP), "asn", len(config.ASN))
+}
+
+// loadRdapConfig reads and validates the config from the source, which is a file path,
+// "online" for IANA, the base url of the bootstrap files, or empty for the embedded config
+func loadRdapConfig(source string) (*RdapConfig, error) {
+	var bootstrap RDAPBootstrap
+	var err error
+	if source == rdapSourceOnline {
+		bootstrap, err = fetchIANABootstrap()
+	} else if isRemoteRDAPSource(source) {
+		client := &http.Client{Timeout: 20 * time.Second}
+		bootstrap, err = fetchRDAPBootstrap(context.Background(), client, source, RDAPData{})
+	} else {
+		bootstrap, err = readRDAPBootstrap(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newRdapConfig(nil, bootstrap)
+}
+
+// newRdapConfig builds the config from the bootstrap merged into base, base is not modified
+func newRdapConfig(base *RdapConfig, bootstrap RDAPBootstrap) (*RdapConfig, error) {
+	config := &RdapConfig{
+		IP:         make(map[string]string),
+		ASN:        make(map[string]string),
+		TLD:        make(map[string]string),
+		ObjectTags: make(map[string]string),
+		ipRanger:   cidranger.NewPCTrieRanger(),
+	}
+	if base != nil {
+		for k, v := range base.IP {
+			config.IP[k] = v
+		}
+		for k, v := range base.ASN {
+			config.ASN[k] = v
+		}
+		for k, v := range base.TLD {
+			config.TLD[k] = v
+		}
+		for k, v := range base.ObjectTags {
+			config.ObjectTags[k] = v
+		}
+	}
+
+	// 合并IPv4和IPv6到IP map中，ASN 和 DNS 转换
+	for _, v := range []struct {
+		services [][][]string
+		target   map[string]string
+	}{
+		{bootstrap.IPv4.Services, config.IP},
+		{bootstrap.IPv6.Services, config.IP},
+		{bootstrap.ASN.Services, config.ASN},
+		{bootstrap.DNS.Services, config.TLD},
+	} {
+		if err := mergeServices(v.services, v.target); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mergeObjectTags(bootstrap.ObjectTags.Services, config.ObjectTags); err != nil {
+		return nil, err
+	}
 
 	// 添加ip Ranger
-	for cidr := range rm.config.IP {
+	for cidr := range config.IP {
 		_, network, err := net.ParseCIDR(cidr)
 		if err != nil {
-			fmt.Printf("解析CIDR '%s' 出错: %v\n", cidr, err)
-			continue // 发生错误时跳过当前项
+			return nil, fmt.Errorf("rdap: invalid cidr %q: %w", cidr, err)
 		}
-		if err := rm.config.ipRanger.Insert(cidranger.NewBasicRangerEntry(*network)); err != nil {
-			fmt.Printf("添加CIDR到ranger时出错: %v\n", err)
-			continue // 发生错误时跳过当前项
+		if err := config.ipRanger.Insert(cidranger.NewBasicRangerEntry(*network)); err != nil {
+			return nil, fmt.Errorf("rdap: add cidr %q failed: %w", cidr, err)
 		}
 	}
 
 	// 添加ASN Ranger
-	var asnRanges []ASNRange
-	for rangeStr, url := range rm.config.ASN {
+	asnRanges := make([]ASNRange, 0, len(config.ASN))
+	for rangeStr, url := range config.ASN {
 		asnRange, err := NewASNRange(rangeStr, url)
 		if err != nil {
-			fmt.Println("Error parsing range:", rangeStr, url, err)
-			continue
+			return nil, fmt.Errorf("rdap: invalid asn range %q: %w", rangeStr, err)
 		}
 		asnRanges = append(asnRanges, asnRange)
 	}
@@ -291,16 +534,17 @@ func (rm *RdapMap) LoadBootstrap(bootstrap RDAPBootstrap) error {
 	sort.Slice(asnRanges, func(i, j int) bool {
 		return asnRanges[i].Start < asnRanges[j].Start
 	})
-	rm.config.asnRanges = asnRanges
+	config.asnRanges = asnRanges
 
-	// 添加asn Ranger
-
-	return nil
+	return config, nil
 }
 
 // mergeServices 将services中的数据按照特定的规则合并到给定的map中
-func mergeServices(services [][][]string, targetMap map[string]string) {
+func mergeServices(services [][][]string, targetMap map[string]string) error {
 	for _, service := range services {
+		if len(service) < 2 {
+			return fmt.Errorf("rdap: invalid service %v", service)
+		}
 		if len(service[1]) > 0 {
 			value := service[1][0] // 使用第二个子数组的第一个元素作为值
 			for _, key := range service[0] {
@@ -308,4 +552,32 @@ func mergeServices(services [][][]string, targetMap map[string]string) {
 			}
 		}
 	}
+	return nil
+}
+
+// mergeObjectTags 将object tags的services合并到map中，service的第二个数组是标签，第三个数组是URL
+func mergeObjectTags(services [][][]string, targetMap map[string]string) error {
+	for _, service := range services {
+		if len(service) < 3 {
+			return fmt.Errorf("rdap: invalid object tags service %v", service)
+		}
+		if len(service[2]) == 0 {
+			continue
+		}
+		value := preferredRDAPURL(service[2])
+		for _, tag := range service[1] {
+			targetMap[strings.ToUpper(tag)] = value
+		}
+	}
+	return nil
+}
+
+// preferredRDAPURL returns the first https url, or the first url if there is no https one
+func preferredRDAPURL(urls []string) string {
+	for _, url := range urls {
+		if strings.HasPrefix(strings.ToLower(url), "https://") {
+			return url
+		}
+	}
+	return urls[0]
 }
@@ -0,0 +1,138 @@
+package whois
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Reload reloads the whois and RDAP configs of the default maps from the sources loaded by InitWhois and InitRDAP,
+// or the embedded configs if not initialized.
+// both configs are validated before any of them is replaced, the current configs are kept on error.
+// the RDAP config from IANA or a mirror is not fetched again, it is refreshed in background
+// by the refresher if EnableRDAPBootstrapRefresh is called, so that a network failure never blocks the whois config.
+// the servers learned from IANA are kept.
+func Reload() error {
+	sm, rm := defaultServerMap(), defaultRdapMap()
+
+	whoisFile := sm.Filename()
+	whoisConfig, err := loadServerConfig(whoisFile)
+	if err != nil {
+		return fmt.Errorf("whois: reload whois config failed: %w", err)
+	}
+
+	// 在线获取的RDAP配置交给刷新器在后台更新，不阻塞本地配置的重新加载
+	if r := defaultBootstrapRefresher(); r != nil {
+		sm.setConfig(whoisConfig, whoisFile)
+		go func() {
+			if _, err := r.Refresh(context.Background()); err != nil {
+				getLogger().Warn("rdap bootstrap refresh failed, keep the current bootstrap", "url", r.baseURL, "error", err)
+			}
+		}()
+		return nil
+	}
+	rdapSource := rm.Source()
+	if isRemoteRDAPSource(rdapSource) {
+		sm.setConfig(whoisConfig, whoisFile)
+		return nil
+	}
+
+	rdapConfig, err := loadRdapConfig(rdapSource)
+	if err != nil {
+		return fmt.Errorf("whois: reload rdap config failed: %w", err)
+	}
+
+	// 两个配置都校验通过后再替换
+	sm.setConfig(whoisConfig, whoisFile)
+	rm.setConfig(rdapConfig, rdapSource)
+
+	return nil
+}
+
+// WatchConfig checks the config files of the default maps every interval until ctx is done,
+// and calls Reload if any of them is modified and then unchanged for an interval. onReload is called with the result of every reload if not nil.
+// the embedded configs, the RDAP config from IANA or a mirror and the RDAP config owned by the refresher are not watched.
+func WatchConfig(ctx context.Context, interval time.Duration, onReload func(error)) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := configFileStates()
+	previous := last
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// 文件在一个检查间隔内不再变化后才重新加载，避免读到写了一半的文件
+		current := configFileStates()
+		stable := !configFilesModified(previous, current)
+		previous = current
+		if !stable || !configFilesModified(last, current) {
+			continue
+		}
+		last = current
+
+		err := Reload()
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+}
+
+// configFileState is the modification state of a config file
+type configFileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// configFileStates returns the states of the config files loaded by the default maps
+func configFileStates() map[string]configFileState {
+	var files []string
+	if filename := defaultServerMap().Filename(); filename != "" {
+		files = append(files, filename)
+	}
+	// 刷新器管理的RDAP配置，包括其缓存文件，不需要监视
+	if source := defaultRdapMap().Source(); source != "" && !isRemoteRDAPSource(source) && defaultBootstrapRefresher() == nil {
+		files = append(files, source)
+	}
+
+	states := make(map[string]configFileState, len(files))
+	for _, filename := range files {
+		// 文件不存在时也记录状态，重新创建后触发重新加载
+		info, err := os.Stat(filename)
+		if err != nil {
+			states[filename] = configFileState{}
+			continue
+		}
+		states[filename] = configFileState{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			exists:  true,
+		}
+	}
+
+	return states
+}
+
+// configFilesModified returns if any watched file is modified, added or removed
+func configFilesModified(last, current map[string]configFileState) bool {
+	if len(last) != len(current) {
+		return true
+	}
+	for filename, state := range current {
+		old, ok := last[filename]
+		if !ok || old.exists != state.exists || old.size != state.size || !old.modTime.Equal(state.modTime) {
+			return true
+		}
+	}
+
+	return false
+}
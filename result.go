@@ -0,0 +1,141 @@
+package whois
+
+import (
+	"strings"
+	"time"
+)
+
+// QueryHop is one query sent to one whois server during a lookup
+type QueryHop struct {
+	// Server is the whois server actually connected
+	Server string `json:"server"`
+	// Port is the whois server port
+	Port string `json:"port"`
+	// Query is the query string actually sent, without the trailing CRLF
+	Query string `json:"query"`
+	// Response is the response of the server, decoded to utf-8
+	Response string `json:"response,omitempty"`
+	// Bytes is the size of the raw response before decoding
+	Bytes int `json:"bytes"`
+	// Charset is the configured or detected charset of the response
+	Charset string `json:"charset,omitempty"`
+	// Truncated marks the response is incomplete, because it exceeds the max size or the read failed halfway
+	Truncated bool `json:"truncated,omitempty"`
+	// Discovery marks the hops used to find the whois server, such as the IANA query
+	Discovery bool `json:"discovery,omitempty"`
+	// Attempt is the attempt number of the query, starts from 1
+	Attempt int `json:"attempt,omitempty"`
+	// Retried marks the attempts which are retried by a later attempt
+	Retried bool `json:"retried,omitempty"`
+	// WaitTime is the time spent waiting for the rate limiter, included in the other times
+	WaitTime time.Duration `json:"wait_time,omitempty"`
+	// ConnectTime is the time spent to connect the server
+	ConnectTime time.Duration `json:"connect_time"`
+	// FirstByteTime is the time from start until the first response byte
+	FirstByteTime time.Duration `json:"first_byte_time"`
+	// TotalTime is the time spent by the whole hop
+	TotalTime time.Duration `json:"total_time"`
+	// Err is the error of the hop, nil if succeeded
+	Err error `json:"-"`
+	// Error is the error message of the hop
+	Error string `json:"error,omitempty"`
+	// failure is the error class of Err, used by the retry policy
+	failure RetryClass
+}
+
+// QueryResult is the structured result of a whois lookup
+type QueryResult struct {
+	// Domain is the normalized query
+	Domain string `json:"domain"`
+	// Hops are all the queries in order, including discovery and referral hops
+	Hops []QueryHop `json:"hops"`
+	// Final is the last succeeded non-discovery and non-retried hop, which is the most authoritative one
+	Final *QueryHop `json:"final,omitempty"`
+	// StartTime is the time the lookup started
+	StartTime time.Time `json:"start_time"`
+	// Duration is the time spent by the whole lookup
+	Duration time.Duration `json:"duration"`
+	// Cache is the cache status, empty if the cache is not enabled
+	Cache CacheStatus `json:"cache,omitempty"`
+	// Shared marks the result is shared with the concurrent identical lookups
+	Shared bool `json:"shared,omitempty"`
+	// Truncated marks any response in String is incomplete
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// RDAPResult is the structured result of a RDAP lookup
+type RDAPResult struct {
+	// Query is the normalized query
+	Query string `json:"query"`
+	// URL is the url of the last request, which is the referral url if followed
+	URL string `json:"url,omitempty"`
+	// Data is the decoded RDAP response
+	Data map[string]interface{} `json:"data,omitempty"`
+	// StartTime is the time the lookup started
+	StartTime time.Time `json:"start_time"`
+	// Duration is the time spent by the whole lookup
+	Duration time.Duration `json:"duration"`
+	// Cache is the cache status, empty if the cache is not enabled
+	Cache CacheStatus `json:"cache,omitempty"`
+	// Shared marks the result is shared with the concurrent identical lookups
+	Shared bool `json:"shared,omitempty"`
+}
+
+// addHop appends a hop to the result
+func (r *QueryResult) addHop(hop QueryHop) {
+	if hop.Err != nil {
+		hop.Error = hop.Err.Error()
+	}
+	r.Hops = append(r.Hops, hop)
+}
+
+// finish sets the final hop and the duration
+func (r *QueryResult) finish() {
+	r.Duration = time.Since(r.StartTime)
+	r.Final = nil
+	r.Truncated = false
+	for _, hop := range r.Hops {
+		if !hop.Discovery && !hop.Retried && hop.Err == nil && hop.Truncated {
+			r.Truncated = true
+		}
+	}
+	for i := len(r.Hops) - 1; i >= 0; i-- {
+		if !r.Hops[i].Discovery && !r.Hops[i].Retried && r.Hops[i].Err == nil {
+			r.Final = &r.Hops[i]
+			break
+		}
+	}
+}
+
+// clone returns a copy of the result which does not share the hops
+func (r *QueryResult) clone() *QueryResult {
+	v := *r
+	v.Hops = append([]QueryHop(nil), r.Hops...)
+	v.finish()
+	v.Duration = r.Duration
+
+	return &v
+}
+
+// String returns the responses of all succeeded non-discovery and non-retried hops joined together,
+// which is the same as the legacy flattened whois result
+func (r *QueryResult) String() string {
+	var sb strings.Builder
+	for _, hop := range r.Hops {
+		if hop.Discovery || hop.Retried || hop.Err != nil {
+			continue
+		}
+		sb.WriteString(hop.Response)
+	}
+	return sb.String()
+}
+
+// clone returns a deep copy of the result
+func (r *RDAPResult) clone() *RDAPResult {
+	v := *r
+	if r.Data != nil {
+		v.Data = copyJSONValue(r.Data).(map[string]interface{})
+	}
+
+	return &v
+}
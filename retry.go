@@ -0,0 +1,163 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/darkqiank/whois/parsers"
+)
+
+// RetryClass is a set of error classes to retry
+type RetryClass int
+
+const (
+	// RetryConnect retries the errors of connecting the server
+	RetryConnect RetryClass = 1 << iota
+	// RetryTimeout retries the read and write timeouts
+	RetryTimeout
+	// RetryRead retries the other read and write errors, such as connection reset
+	RetryRead
+	// RetryLimitExceeded retries the responses saying the query limit is exceeded
+	RetryLimitExceeded
+
+	// RetryTransient retries all the transient network errors
+	RetryTransient = RetryConnect | RetryTimeout | RetryRead
+	// RetryAll retries all the error classes
+	RetryAll = RetryTransient | RetryLimitExceeded
+)
+
+// RetryPolicy is the retry policy of whois queries,
+// the zero value does not retry
+type RetryPolicy struct {
+	// MaxAttempts is the max attempts of a query including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff is the max delay between retries, 0 means no limit
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of the delay, 2 if not greater than 1
+	Multiplier float64
+	// Jitter is the random fraction added to or removed from the delay, such as 0.2
+	Jitter float64
+	// RetryOn is the error classes to retry
+	RetryOn RetryClass
+}
+
+// DefaultRetryPolicy returns a retry policy with 3 attempts for transient network errors
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn:        RetryTransient,
+	}
+}
+
+// backoff returns the delay before the retry after the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1) //nolint:gosec
+	}
+
+	return time.Duration(delay)
+}
+
+// SetRetryPolicy set the retry policy of the queries
+func (c *Client) SetRetryPolicy(policy RetryPolicy) *Client {
+	c.retryPolicy = policy
+	return c
+}
+
+// retryQuery do the query with the retry policy and returns the last attempt,
+// every attempt is added to the result, the retried ones are marked
+func (c *Client) retryQuery(ctx context.Context, result *QueryResult, query func() QueryHop) QueryHop {
+	policy := c.retryPolicy
+	for attempt := 1; ; attempt++ {
+		hop := query()
+		hop.Attempt = attempt
+		c.logHop(result, hop)
+
+		class := hopFailure(hop)
+		if attempt >= policy.MaxAttempts || class&policy.RetryOn == 0 || ctx.Err() != nil {
+			result.addHop(hop)
+			return hop
+		}
+
+		hop.Retried = true
+		result.addHop(hop)
+		if !sleepContext(ctx, policy.backoff(attempt)) {
+			// 等待期间ctx被取消，最后一次尝试作为结果
+			result.Hops[len(result.Hops)-1].Retried = false
+			return result.Hops[len(result.Hops)-1]
+		}
+	}
+}
+
+// logHop logs the hop of the lookup
+func (c *Client) logHop(result *QueryResult, hop QueryHop) {
+	args := []interface{}{"query", result.Domain, "server", hop.Server, "port", hop.Port,
+		"hop", len(result.Hops) + 1, "attempt", hop.Attempt, "duration", hop.TotalTime}
+	if hop.WaitTime > 0 {
+		args = append(args, "wait", hop.WaitTime)
+	}
+	if hop.Truncated {
+		args = append(args, "truncated", true)
+	}
+	if hop.Err != nil {
+		args = append(args, "error", hop.Err)
+	}
+	c.log().Debug("whois hop", args...)
+}
+
+// hopFailure returns the error class of the hop, 0 if succeeded
+func hopFailure(hop QueryHop) RetryClass {
+	// 读取中断但保留了部分数据的hop也按失败类型重试
+	if hop.Err != nil || hop.Truncated {
+		return hop.failure
+	}
+
+	if parsers.IsLimitExceeded(hop.Response) {
+		return RetryLimitExceeded
+	}
+
+	return 0
+}
+
+// ioFailure returns the error class of a read or write error
+func ioFailure(err error) RetryClass {
+	var nerr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()) {
+		return RetryTimeout
+	}
+
+	return RetryRead
+}
+
+// sleepContext sleeps for the duration, returns false if ctx is done before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,244 @@
+package whois
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rwhoisScheme is the url scheme of rwhois referrals
+	rwhoisScheme = "rwhois://"
+	// defaultRWhoisPort is default rwhois port
+	defaultRWhoisPort = "4321"
+)
+
+// RWhoisError is the %error response of a rwhois server, such as "%error 230 No Objects Found"
+type RWhoisError struct {
+	Code    int
+	Message string
+}
+
+// Error implements error
+func (e *RWhoisError) Error() string {
+	return fmt.Sprintf("whois: rwhois error %d %s", e.Code, e.Message)
+}
+
+// rwhoisSession is a rwhois connection after the banner handshake
+type rwhoisSession struct {
+	ctx     context.Context
+	conn    net.Conn
+	reader  *bufio.Reader
+	limited *io.LimitedReader
+	stop    func()
+}
+
+// rwhoisQuery do rwhois query to the server and returns the hop
+func (c *Client) rwhoisQuery(ctx context.Context, domain, server, port string) (hop QueryHop) {
+	start := time.Now()
+	defer func() {
+		hop.TotalTime = time.Since(start)
+	}()
+
+	hop, options := c.newHop(domain, server, port)
+
+	done, err := c.waitLimiter(ctx, &hop, options)
+	if err != nil {
+		return
+	}
+	defer func() {
+		done(hop)
+	}()
+
+	ctx, cancel := c.hopContext(ctx, options)
+	defer cancel()
+
+	session, failure, err := c.rwhoisConnect(ctx, hop.Server, hop.Port)
+	if err != nil {
+		hop.Err = fmt.Errorf("whois: connect to rwhois server (%s) failed: %w", hop.Server, err)
+		hop.failure = failure
+		return
+	}
+	hop.ConnectTime = time.Since(start)
+	hop.FirstByteTime = hop.ConnectTime
+
+	// 请求保持连接以便在同一连接上查询，服务器不支持该指令时返回%error，可以继续查询；
+	// 如果服务器直接关闭了连接，重新连接后直接查询
+	if _, err := session.command("-holdconnect on"); err != nil {
+		var rerr *RWhoisError
+		if !errors.As(err, &rerr) {
+			session.close()
+			session, failure, err = c.rwhoisConnect(ctx, hop.Server, hop.Port)
+			if err != nil {
+				hop.Err = fmt.Errorf("whois: connect to rwhois server (%s) failed: %w", hop.Server, err)
+				hop.failure = failure
+				return
+			}
+		}
+	}
+	defer session.close()
+
+	lines, err := session.command(hop.Query)
+	exceeded := session.exceeded()
+	if len(lines) > 0 {
+		// 截断的最后一行不是完整的行，不添加换行，以便去掉被切开的多字节字符
+		var rerr *RWhoisError
+		truncated := exceeded || (err != nil && !errors.As(err, &rerr))
+		data := []byte(strings.Join(lines, "\n"))
+		if !truncated {
+			data = append(data, '\n')
+		}
+		hop.Response, hop.Charset = decodeResponse(data, options.Charset, domain, truncated)
+		hop.Bytes = len(data)
+	}
+	// 超过最大长度时丢弃剩余的数据
+	if exceeded {
+		hop.Truncated = true
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("whois: query rwhois server (%s) failed: %w", hop.Server, err)
+		var rerr *RWhoisError
+		if errors.As(err, &rerr) {
+			hop.Err = err
+			return
+		}
+		hop.failure = ioFailure(err)
+		if len(lines) == 0 {
+			hop.Err = err
+			return
+		}
+		// 保留已读取的部分数据，标记为截断
+		hop.Error = err.Error()
+		hop.Truncated = true
+		return
+	}
+
+	_ = session.write("-quit")
+
+	return
+}
+
+// rwhoisConnect connects to the rwhois server and reads the %rwhois banner, returns the error class on error,
+// the banner errors are read failures so that a server not speaking rwhois is not taken as unreachable
+func (c *Client) rwhoisConnect(ctx context.Context, server, port string) (*rwhoisSession, RetryClass, error) {
+	conn, err := c.transport.DialContext(ctx, "tcp", net.JoinHostPort(server, port))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, RetryConnect, ctx.Err()
+		}
+		return nil, RetryConnect, err
+	}
+	setDeadline(ctx, conn)
+
+	session := &rwhoisSession{
+		ctx:  ctx,
+		conn: conn,
+		stop: closeOnDone(ctx, conn),
+	}
+	if c.maxResponseSize > 0 {
+		// 多读一个字节用于判断是否超过最大长度
+		session.limited = &io.LimitedReader{R: conn, N: c.maxResponseSize + 1}
+		session.reader = bufio.NewReader(session.limited)
+	} else {
+		session.reader = bufio.NewReader(conn)
+	}
+
+	banner, err := session.readLine()
+	if err != nil {
+		session.close()
+		return nil, ioFailure(err), err
+	}
+
+	if !strings.HasPrefix(banner, "%rwhois") {
+		session.close()
+		return nil, RetryRead, fmt.Errorf("%w: %s", ErrRWhoisBanner, banner)
+	}
+
+	return session, 0, nil
+}
+
+// command sends a line to the server and reads the response lines until %ok or %error
+func (s *rwhoisSession) command(line string) ([]string, error) {
+	if err := s.write(line); err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	for {
+		text, err := s.readLine()
+		if err != nil {
+			// 服务器没有返回状态就关闭了连接，保留已读取的数据
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				return lines, nil
+			}
+			return lines, err
+		}
+
+		switch {
+		case strings.HasPrefix(text, "%ok"):
+			return lines, nil
+		case strings.HasPrefix(text, "%error"):
+			return lines, parseRWhoisError(text)
+		}
+
+		lines = append(lines, text)
+	}
+}
+
+// write sends a line to the server
+func (s *rwhoisSession) write(line string) error {
+	_, err := s.conn.Write([]byte(line + "\r\n"))
+	if err != nil && s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	return err
+}
+
+// readLine reads a line without the line ending
+func (s *rwhoisSession) readLine() (string, error) {
+	text, err := s.reader.ReadString('\n')
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return "", s.ctx.Err()
+		}
+		if !errors.Is(err, io.EOF) || text == "" {
+			return "", err
+		}
+	}
+
+	return strings.TrimRight(text, "\r\n"), nil
+}
+
+// exceeded returns if the session has read more than the max response size
+func (s *rwhoisSession) exceeded() bool {
+	return s.limited != nil && s.limited.N <= 0
+}
+
+// close closes the connection
+func (s *rwhoisSession) close() {
+	s.stop()
+	_ = s.conn.Close()
+}
+
+// parseRWhoisError parses the %error line
+func parseRWhoisError(line string) error {
+	fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "%error")), " ", 2)
+	code, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return &RWhoisError{Message: strings.TrimSpace(strings.TrimPrefix(line, "%error"))}
+	}
+
+	rerr := &RWhoisError{Code: code}
+	if len(fields) > 1 {
+		rerr.Message = strings.TrimSpace(fields[1])
+	}
+
+	return rerr
+}
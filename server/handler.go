@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 
+	"github.com/darkqiank/whois"
 	parser "github.com/darkqiank/whois/parsers"
 	"github.com/gofiber/fiber/v2"
 )
@@ -24,20 +27,19 @@ func WhoisHandler(c *fiber.Ctx) error {
 		return sendJSONResponse(c, fiber.StatusBadRequest, nil, fmt.Errorf("domain not specified"))
 	}
 
-	// 初始化disableReferral为true
-	disableReferral := true
-
-	// 检查是否有ref查询参数传入
-	ref := c.Query("ref")
-	if ref != "" {
-		disableReferral = false
+	// 检查是否有ref查询参数传入，未传入时不跟随referral，
+	// 传入1到10时作为最大referral深度，0和其他值与之前一样跟随referral，使用默认深度
+	referralDepth, err := parseReferralDepth(c.Query("ref"))
+	if err != nil {
+		return sendJSONResponse(c, fiber.StatusBadRequest, nil, err)
 	}
 
 	// 检查是否有tip查询参数传入
 	tip := c.Query("tip")
 
-	// 获取Whois数据
-	whois, err := GetWhois(domain, disableReferral)
+	// 获取Whois数据，请求上下文被取消（如超时中间件）时中止查询
+	whois, info, err := GetWhois(c.UserContext(), domain, referralDepth)
+	setLookupInfo(c, info)
 	if err != nil {
 		if tip == "1" {
 			return c.Status(fiber.StatusInternalServerError).JSON(nil)
@@ -66,6 +68,27 @@ func WhoisHandler(c *fiber.Ctx) error {
 	return sendJSONResponse(c, fiber.StatusOK, whois, nil)
 }
 
+// maxReferralDepth 限制ref参数允许的最大referral深度
+const maxReferralDepth = 10
+
+// parseReferralDepth 解析ref参数，返回0表示不跟随，负数表示使用默认深度。
+// ref=0兼容之前的行为，开启referral并使用默认深度，非数字或超出范围时返回错误
+func parseReferralDepth(ref string) (int, error) {
+	if ref == "" {
+		return 0, nil
+	}
+
+	depth, err := strconv.Atoi(ref)
+	if err != nil || depth < 0 || depth > maxReferralDepth {
+		return 0, fmt.Errorf("ref must be between 0 and %d", maxReferralDepth)
+	}
+	if depth == 0 {
+		return -1, nil
+	}
+
+	return depth, nil
+}
+
 // convertToTipResponse 将WhoisInfo转换为TipResponse格式
 func convertToTipResponse(whois parser.WhoisInfo) (*TipResponse, error) {
 	if whois.Domain == nil {
@@ -173,7 +196,8 @@ func RdapHandler(c *fiber.Ctx) error {
 		disableReferral = false
 	}
 	// 获取rdap数据
-	rdap, err := GetRDAP(domain, disableReferral)
+	rdap, info, err := GetRDAP(c.UserContext(), domain, disableReferral)
+	setLookupInfo(c, info)
 	if err != nil {
 		return sendJSONResponse(c, fiber.StatusInternalServerError, rdap, err)
 	}
@@ -199,11 +223,77 @@ func RdapHandler(c *fiber.Ctx) error {
 
 }
 
+// RdapNameserverHandler 用于处理nameserver的RDAP查询，按nameserver的顶级域名查找RDAP服务器
+func RdapNameserverHandler(c *fiber.Ctx) error {
+	// 从URL路径中提取nameserver
+	name := c.Params("*")
+	if name == "" {
+		return sendJSONResponse(c, fiber.StatusBadRequest, nil, fmt.Errorf("nameserver not specified"))
+	}
+
+	nameserver, info, err := GetRDAPNameserver(c.UserContext(), name, c.Query("ref") == "")
+	setLookupInfo(c, info)
+	if err != nil {
+		return sendJSONResponse(c, rdapErrorStatus(err), nil, err)
+	}
+
+	return sendJSONResponse(c, fiber.StatusOK, nameserver, nil)
+}
+
+// RdapRegistrarHandler 用于按IANA ID查询注册商的RDAP信息，tld参数指定查询的注册局，默认为com
+func RdapRegistrarHandler(c *fiber.Ctx) error {
+	ianaID := c.Params("id")
+	if _, err := strconv.ParseUint(ianaID, 10, 32); err != nil {
+		return sendJSONResponse(c, fiber.StatusBadRequest, nil, fmt.Errorf("invalid registrar iana id %q", ianaID))
+	}
+
+	registrar, info, err := GetRDAPRegistrar(c.UserContext(), ianaID, c.Query("tld"), c.Query("ref") == "")
+	setLookupInfo(c, info)
+	if err != nil {
+		return sendJSONResponse(c, rdapErrorStatus(err), nil, err)
+	}
+
+	return sendJSONResponse(c, fiber.StatusOK, registrar, nil)
+}
+
+// rdapErrorStatus 返回RDAP查询错误对应的状态码，找不到服务器或对象时返回404
+func rdapErrorStatus(err error) int {
+	if errors.Is(err, whois.ErrRDAPServerNotFound) || errors.Is(err, whois.ErrRDAPNotFound) {
+		return fiber.StatusNotFound
+	}
+
+	return fiber.StatusInternalServerError
+}
+
+const (
+	// cacheHeader 是返回缓存命中状态的响应头
+	cacheHeader = "X-Cache"
+	// truncatedHeader 是标记whois数据不完整的响应头
+	truncatedHeader = "X-Truncated"
+	// lookupInfoKey 是查询结果元数据在请求Locals中的key
+	lookupInfoKey = "lookupInfo"
+)
+
+// setLookupInfo 保存查询结果的元数据，并设置对应的响应头
+func setLookupInfo(c *fiber.Ctx, info LookupInfo) {
+	c.Locals(lookupInfoKey, info)
+	if info.Cache != "" {
+		c.Set(cacheHeader, strings.ToUpper(string(info.Cache)))
+	}
+	if info.Truncated {
+		c.Set(truncatedHeader, "true")
+	}
+}
+
 // sendJSONResponse 使用Fiber发送JSON响应
 func sendJSONResponse(c *fiber.Ctx, statusCode int, data interface{}, err error) error {
 	response := Response{
 		Success: err == nil,
 	}
+	if info, ok := c.Locals(lookupInfoKey).(LookupInfo); ok {
+		response.Cache = string(info.Cache)
+		response.Truncated = info.Truncated
+	}
 
 	if err != nil {
 		response.Error = err.Error()
@@ -213,3 +303,13 @@ func sendJSONResponse(c *fiber.Ctx, statusCode int, data interface{}, err error)
 	// Fiber自带的JSON方法可以直接返回JSON响应
 	return c.Status(statusCode).JSON(response)
 }
+
+// RDAPBootstrapStatusHandler 返回RDAP bootstrap的刷新状态，未启用刷新时返回404
+func RDAPBootstrapStatusHandler(c *fiber.Ctx) error {
+	status, ok := whois.RDAPBootstrapStatus()
+	if !ok {
+		return sendJSONResponse(c, fiber.StatusNotFound, nil, fmt.Errorf("rdap bootstrap refresh not enabled"))
+	}
+
+	return sendJSONResponse(c, fiber.StatusOK, status, nil)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/darkqiank/whois"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseReferralDepth(t *testing.T) {
+	tests := []struct {
+		ref   string
+		depth int
+		err   bool
+	}{
+		{"", 0, false},
+		{"0", -1, false},
+		{"1", 1, false},
+		{"5", 5, false},
+		{"10", 10, false},
+		{"true", 0, true},
+		{"abc", 0, true},
+		{"-1", 0, true},
+		{"11", 0, true},
+		{"100", 0, true},
+	}
+
+	for _, v := range tests {
+		depth, err := parseReferralDepth(v.ref)
+		if (err != nil) != v.err {
+			t.Fatalf("parse ref %q: unexpected error: %v", v.ref, err)
+		}
+		if err == nil && depth != v.depth {
+			t.Fatalf("parse ref %q: expect depth %d but got %d", v.ref, v.depth, depth)
+		}
+	}
+}
+
+func TestRdapErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w for nameserver ns1.example.none", whois.ErrRDAPServerNotFound), fiber.StatusNotFound},
+		{fmt.Errorf("rdap: query rdap server failed: (%w)", whois.ErrRDAPNotFound), fiber.StatusNotFound},
+		{errors.New("unexpected status code: 500"), fiber.StatusInternalServerError},
+	}
+
+	for _, v := range tests {
+		if status := rdapErrorStatus(v.err); status != v.status {
+			t.Fatalf("error %v: expect status %d but got %d", v.err, v.status, status)
+		}
+	}
+}
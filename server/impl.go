@@ -1,35 +1,123 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/darkqiank/whois"
 	parser "github.com/darkqiank/whois/parsers"
 	"golang.org/x/net/proxy"
 )
 
-// GetWhois does a WHOIS lookup for a supplied domain
-func GetWhois(domain string, disableReferral bool) (parser.WhoisInfo, error) {
-	c := whois.NewClient().SetDialer(proxy.FromEnvironment())
-	c.SetDisableReferral(disableReferral)
-	raw, err := c.Whois(domain)
+var (
+	// lookupCache 是所有请求共享的查询结果缓存，nil表示不缓存
+	lookupCache    whois.Cache = whois.NewMemoryCache(0)
+	lookupCacheTTL             = whois.DefaultCacheTTL()
+	lookupCacheMu  sync.RWMutex
+
+	// lookupDialer 是所有请求共享的代理dialer
+	lookupDialer proxy.Dialer
+	dialerOnce   sync.Once
+)
+
+// SetCache set the lookup cache shared by all the requests, nil disables the cache
+func SetCache(cache whois.Cache, ttl whois.CacheTTL) {
+	lookupCacheMu.Lock()
+	defer lookupCacheMu.Unlock()
+
+	lookupCache, lookupCacheTTL = cache, ttl
+}
+
+// getDialer returns the proxy dialer from the environment, which is shared by all the requests
+// so that the concurrent identical lookups are coalesced
+func getDialer() proxy.Dialer {
+	dialerOnce.Do(func() {
+		lookupDialer = proxy.FromEnvironment()
+	})
+
+	return lookupDialer
+}
+
+// getCache returns the lookup cache and the cache ttl
+func getCache() (whois.Cache, whois.CacheTTL) {
+	lookupCacheMu.RLock()
+	defer lookupCacheMu.RUnlock()
+
+	return lookupCache, lookupCacheTTL
+}
 
-	result, err1 := parser.Parse(raw)
+// GetWhois does a WHOIS lookup for a supplied domain,
+// referralDepth is the max referral hops to follow, 0 disables and negative uses the default
+func GetWhois(ctx context.Context, domain string, referralDepth int) (parser.WhoisInfo, LookupInfo, error) {
+	cache, ttl := getCache()
+	c := whois.NewClient().SetDialer(getDialer()).SetCache(cache).SetCacheTTL(ttl)
+	if referralDepth == 0 {
+		c.SetDisableReferral(true)
+	} else if referralDepth > 0 {
+		c.SetMaxReferralDepth(referralDepth)
+	}
+	r, err := c.QueryContext(ctx, domain)
+	info := LookupInfo{Cache: r.Cache, Truncated: r.Truncated}
+
+	result, err1 := parser.Parse(strings.TrimSpace(r.String()))
 	if err1 != nil {
-		return parser.WhoisInfo{}, err1
+		if r.Truncated {
+			// 不完整的数据无法解析时说明原因
+			err1 = fmt.Errorf("whois data is truncated: %w", err1)
+		}
+		return parser.WhoisInfo{}, info, err1
 	}
 
-	return result, err
+	return result, info, err
 }
 
 // GetRDAP does a RDAP lookup for a supplied domain
-func GetRDAP(domain string, disableReferral bool) (parser.RDAPInfo, error) {
-	c := whois.NewRDAPClient()
+func GetRDAP(ctx context.Context, domain string, disableReferral bool) (parser.RDAPInfo, LookupInfo, error) {
+	cache, ttl := getCache()
+	c := whois.NewRDAPClient().SetCache(cache).SetCacheTTL(ttl)
 	c.SetDisableReferral(disableReferral)
-	raw, err := c.RDAP(domain)
+	r, err := c.QueryContext(ctx, domain)
+	info := LookupInfo{Cache: r.Cache}
+	if err != nil {
+		return parser.RDAPInfo{}, info, err
+	}
 
-	result, err1 := parser.ParseRDAPResponse(raw)
+	result, err1 := parser.ParseRDAPResponse(r.Data)
 	if err1 != nil {
-		return parser.RDAPInfo{}, err1
+		return parser.RDAPInfo{}, info, err1
+	}
+
+	return result, info, nil
+}
+
+// GetRDAPNameserver does a RDAP lookup for a supplied nameserver
+func GetRDAPNameserver(ctx context.Context, name string, disableReferral bool) (parser.NameserverInfo, LookupInfo, error) {
+	cache, ttl := getCache()
+	c := whois.NewRDAPClient().SetCache(cache).SetCacheTTL(ttl)
+	c.SetDisableReferral(disableReferral)
+	r, err := c.QueryNameserverContext(ctx, name)
+	info := LookupInfo{Cache: r.Cache}
+	if err != nil {
+		return parser.NameserverInfo{}, info, err
+	}
+
+	result, err := parser.ParseRDAPResponseForNameserver(r.Data)
+	return result, info, err
+}
+
+// GetRDAPRegistrar does a RDAP lookup for the registrar of a supplied IANA ID from the registry of the TLD
+func GetRDAPRegistrar(ctx context.Context, ianaID, tld string, disableReferral bool) (parser.RegistrarInfo, LookupInfo, error) {
+	cache, ttl := getCache()
+	c := whois.NewRDAPClient().SetCache(cache).SetCacheTTL(ttl)
+	c.SetDisableReferral(disableReferral)
+	r, err := c.QueryRegistrarContext(ctx, ianaID, tld)
+	info := LookupInfo{Cache: r.Cache}
+	if err != nil {
+		return parser.RegistrarInfo{}, info, err
 	}
 
-	return result, err
+	result, err := parser.ParseRDAPResponseForRegistrar(r.Data)
+	return result, info, err
 }
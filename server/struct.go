@@ -1,5 +1,7 @@
 package server
 
+import "github.com/darkqiank/whois"
+
 // SingleBody defines the JSON body for
 // getting Whois data of a single domain
 type SingleBody struct {
@@ -7,9 +9,19 @@ type SingleBody struct {
 }
 
 type Response struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Cache     string      `json:"cache,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// LookupInfo 是查询结果的元数据
+type LookupInfo struct {
+	// Cache 是缓存命中状态，未启用缓存时为空
+	Cache whois.CacheStatus
+	// Truncated 表示whois数据不完整
+	Truncated bool
 }
 
 // TipResponse 用于 tip=1 参数时返回的扁平化格式
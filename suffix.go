@@ -0,0 +1,185 @@
+package whois
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// privateDomainsMarker starts the private domains section of the public suffix list,
+// which is skipped because whois is served by the icann registries
+const privateDomainsMarker = "===BEGIN PRIVATE DOMAINS==="
+
+// suffixRules is the icann section of a public suffix list file
+type suffixRules struct {
+	rules      map[string]bool
+	wildcards  map[string]bool
+	exceptions map[string]bool
+}
+
+var (
+	// suffixList is the loaded public suffix list, nil means the list embedded in golang.org/x/net
+	suffixList   *suffixRules
+	suffixListMu sync.RWMutex
+)
+
+// LoadPublicSuffixList loads the public suffix list from a file in the publicsuffix.org format,
+// it replaces the embedded list, an empty filename restores the embedded list.
+func LoadPublicSuffixList(filename string) error {
+	if filename == "" {
+		suffixListMu.Lock()
+		suffixList = nil
+		suffixListMu.Unlock()
+		return nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := parseSuffixRules(f)
+	if err != nil {
+		return err
+	}
+
+	suffixListMu.Lock()
+	suffixList = rules
+	suffixListMu.Unlock()
+
+	return nil
+}
+
+// parseSuffixRules parses the icann section of a public suffix list
+func parseSuffixRules(r io.Reader) (*suffixRules, error) {
+	rules := &suffixRules{
+		rules:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.Contains(line, privateDomainsMarker) {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		rule := strings.ToLower(strings.Fields(line)[0])
+		if v, err := idna.ToASCII(rule); err == nil {
+			rule = v
+		}
+
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			rules.exceptions[rule[1:]] = true
+		case strings.HasPrefix(rule, "*."):
+			rules.wildcards[rule[2:]] = true
+		default:
+			rules.rules[rule] = true
+		}
+	}
+
+	return rules, scanner.Err()
+}
+
+// publicSuffix returns the public suffix of the ascii domain by the rules
+func (r *suffixRules) publicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	// 从最长的后缀开始匹配，例外规则总比对应的通配规则长
+	for i := 0; i < len(labels); i++ {
+		candidate := strings.Join(labels[i:], ".")
+		if r.exceptions[candidate] {
+			return strings.Join(labels[i+1:], ".")
+		}
+		if r.rules[candidate] {
+			return candidate
+		}
+		if i+1 < len(labels) && r.wildcards[strings.Join(labels[i+1:], ".")] {
+			return candidate
+		}
+	}
+
+	return labels[len(labels)-1]
+}
+
+// publicSuffix returns the icann public suffix of the ascii domain
+func publicSuffix(domain string) string {
+	suffixListMu.RLock()
+	rules := suffixList
+	suffixListMu.RUnlock()
+
+	if rules != nil {
+		return rules.publicSuffix(domain)
+	}
+
+	suffix, icann := publicsuffix.PublicSuffix(domain)
+	// 私有后缀（如github.io）向上找到icann管理的后缀
+	for !icann && strings.Contains(suffix, ".") {
+		suffix, icann = publicsuffix.PublicSuffix(suffix[strings.Index(suffix, ".")+1:])
+	}
+
+	return suffix
+}
+
+// registrableDomain returns the domain reduced to the public suffix plus one label,
+// such as www.example.co.uk to example.co.uk, the domain is returned as is if it can not be reduced.
+func registrableDomain(domain string) string {
+	if !isDomainName(domain) {
+		return domain
+	}
+
+	ascii, err := idna.ToASCII(strings.ToLower(domain))
+	if err != nil {
+		return domain
+	}
+
+	labels := strings.Split(domain, ".")
+	asciiLabels := strings.Split(ascii, ".")
+	if len(labels) != len(asciiLabels) {
+		return domain
+	}
+
+	suffix := publicSuffix(ascii)
+	n := strings.Count(suffix, ".") + 2
+	if n > len(labels) {
+		return domain
+	}
+
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// domainSuffixes returns the suffixes of the domain to lookup the whois server, longest first,
+// for example example.co.uk returns co.uk and uk
+func domainSuffixes(domain string) []string {
+	domain = strings.ToLower(domain)
+	if !isDomainName(domain) {
+		return []string{getExtension(domain)}
+	}
+
+	labels := strings.Split(domain, ".")
+	suffixes := make([]string, 0, len(labels)-1)
+	for i := 1; i < len(labels); i++ {
+		suffixes = append(suffixes, strings.Join(labels[i:], "."))
+	}
+
+	return suffixes
+}
+
+// isDomainName returns if the query looks like a domain name rather than an ip, cidr or asn
+func isDomainName(query string) bool {
+	return strings.Contains(query, ".") &&
+		!strings.ContainsAny(query, ":/") &&
+		!IsASN(query) &&
+		net.ParseIP(query) == nil
+}
@@ -0,0 +1,38 @@
+package whois
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/net/proxy"
+)
+
+// Transport opens the connections to the whois and rwhois servers,
+// it must be safe for concurrent use
+type Transport interface {
+	// DialContext connects to the address on the named network
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// defaultTransport is the transport shared by the clients created by NewClient
+var defaultTransport Transport = dialerTransport{
+	dialer: &net.Dialer{
+		Timeout: defaultTimeout,
+	},
+}
+
+// dialerTransport is the transport of a proxy dialer
+type dialerTransport struct {
+	dialer proxy.Dialer
+}
+
+// DialContext connects to the address by the dialer
+func (t dialerTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialContext(ctx, t.dialer, network, addr)
+}
+
+// SetTransport set the transport of the queries, such as a fake server in tests
+func (c *Client) SetTransport(transport Transport) *Client {
+	c.transport = transport
+	return c
+}
@@ -2,7 +2,10 @@ package whois
 
 import (
 	"net"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +21,22 @@ func IsASN(resource string) bool {
 	return asnRegex.MatchString(s)
 }
 
+// parseASN returns the number of an asn query, such as AS123, ASN123 or 123
+func parseASN(query string) (int, bool) {
+	if !IsASN(query) {
+		return 0, false
+	}
+
+	query = strings.ToLower(query)
+	query = strings.TrimPrefix(strings.TrimPrefix(query, "asn"), "as")
+	asn, err := strconv.Atoi(query)
+	if err != nil {
+		return 0, false
+	}
+
+	return asn, true
+}
+
 // getExtension returns extension of domain
 func getExtension(domain string) string {
 	ext := domain
@@ -34,6 +53,19 @@ func getExtension(domain string) string {
 	return ext
 }
 
+// parseIPQuery returns the ip of an ip or cidr query, nil if it is not
+func parseIPQuery(query string) net.IP {
+	if ip := net.ParseIP(query); ip != nil {
+		return ip
+	}
+
+	if ip, _, err := net.ParseCIDR(query); err == nil {
+		return ip
+	}
+
+	return nil
+}
+
 // extractHostname 从可能的URL中提取主机名
 func extractHostname(url string) string {
 	// 转小写
@@ -58,3 +90,33 @@ func extractHostname(url string) string {
 
 	return url
 }
+
+// writeFileAtomic writes the data to a temp file in the same directory and renames it to the filename,
+// so that the file is never partially written. the mode of the existing file is kept, 0644 for a new file.
+func writeFileAtomic(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// CreateTemp创建的文件权限为0600
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -24,16 +24,23 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/darkqiank/whois/parsers"
 	"golang.org/x/net/proxy"
 )
 
 var (
-	serverMapInstance *serverMap
-	onceWhois         sync.Once
+	// serverMapInstance 是默认的服务器配置，查询时无锁读取
+	serverMapInstance atomic.Pointer[ServerMap]
+	// serverMapInited 标记InitWhois已被显式调用
+	serverMapInited bool
+	// serverMapMu 保护默认配置的加载和InitWhois
+	serverMapMu sync.Mutex
 )
 
 const (
@@ -41,24 +48,36 @@ const (
 	defaultWhoisServer = "whois.iana.org"
 	// defaultWhoisPort is default whois port
 	defaultWhoisPort = "43"
-	// defaultElapsedTimeout
+	// defaultElapsedTimeout is the default timeout of the whole lookup
 	defaultElapsedTimeout = 15 * time.Second
-	// defaultTimeout is query default timeout
+	// defaultTimeout is the default dial timeout
 	defaultTimeout = 5 * time.Second
+	// defaultMaxReferralDepth is the default max number of referral hops to follow,
+	// it keeps the single hop behavior of the earlier versions
+	defaultMaxReferralDepth = 1
+	// defaultMaxResponseSize is the default max bytes of a response
+	defaultMaxResponseSize = 1 << 20
 )
 
 // DefaultClient is default whois client
 var DefaultClient = NewClient()
 
-// Client is whois client
+// Client is whois client, it is safe for concurrent queries,
+// but the settings must not be changed while querying
 type Client struct {
-	dialer          proxy.Dialer
-	timeout         time.Duration
-	elapsed         time.Duration
-	disableStats    bool
-	disableReferral bool
-
-	serverMap *serverMap
+	transport        Transport
+	timeout          time.Duration
+	disableStats     bool
+	disableReferral  bool
+	maxReferralDepth int
+	retryPolicy      RetryPolicy
+	maxResponseSize  int64
+	limiter          *Limiter
+	cache            Cache
+	cacheTTL         CacheTTL
+	logger           Logger
+
+	serverMap *ServerMap
 }
 
 // Version returns package version
@@ -81,24 +100,51 @@ func Whois(domain string, servers ...string) (result string, err error) {
 	return DefaultClient.Whois(domain, servers...)
 }
 
-// NewClient returns new whois client
-func NewClient() *Client {
-	return &Client{
-		dialer: &net.Dialer{
-			Timeout: defaultTimeout,
-		},
-		timeout:   defaultElapsedTimeout,
-		serverMap: serverMapInstance,
+// WhoisContext do the whois query with context and returns whois information
+func WhoisContext(ctx context.Context, domain string, servers ...string) (result string, err error) {
+	return DefaultClient.WhoisContext(ctx, domain, servers...)
+}
+
+// NewClient returns new whois client, the default server map is used if WithServerMap is not set
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		transport:        defaultTransport,
+		timeout:          defaultElapsedTimeout,
+		maxReferralDepth: defaultMaxReferralDepth,
+		maxResponseSize:  defaultMaxResponseSize,
+		limiter:          DefaultLimiter,
+		cacheTTL:         DefaultCacheTTL(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
-// SetDialer set query net dialer
+// SetDialer set query net dialer, it replaces the transport
 func (c *Client) SetDialer(dialer proxy.Dialer) *Client {
-	c.dialer = dialer
+	c.transport = dialerTransport{dialer: dialer}
 	return c
 }
 
-// SetTimeout set query timeout
+// SetLogger set the logger of the queries, the package logger is used if nil
+func (c *Client) SetLogger(logger Logger) *Client {
+	c.logger = logger
+	return c
+}
+
+// log returns the logger of the client, the package logger if not set
+func (c *Client) log() Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+
+	return getLogger()
+}
+
+// SetTimeout set the timeout of the whole lookup, which bounds the dial, write and read
+// of every hop including the discovery, the retries and the referrals
 func (c *Client) SetTimeout(timeout time.Duration) *Client {
 	c.timeout = timeout
 	return c
@@ -116,8 +162,32 @@ func (c *Client) SetDisableReferral(disabled bool) *Client {
 	return c
 }
 
+// SetMaxResponseSize set the max bytes of a response, the rest is dropped and the hop is marked truncated,
+// not greater than 0 means no limit
+func (c *Client) SetMaxResponseSize(size int64) *Client {
+	c.maxResponseSize = size
+	return c
+}
+
+// SetMaxReferralDepth set the max number of referral hops to follow,
+// for example registry -> registrar -> reseller needs 2, 0 disables the referral.
+// the default is 1, which follows only the referral of the registry like the earlier versions.
+func (c *Client) SetMaxReferralDepth(depth int) *Client {
+	if depth < 0 {
+		depth = 0
+	}
+	c.maxReferralDepth = depth
+	return c
+}
+
 // Whois do the whois query and returns whois information
 func (c *Client) Whois(domain string, servers ...string) (result string, err error) {
+	return c.WhoisContext(context.Background(), domain, servers...)
+}
+
+// WhoisContext do the whois query and returns whois information,
+// the ctx cancels dialing, writing, reading and every referral query
+func (c *Client) WhoisContext(ctx context.Context, domain string, servers ...string) (result string, err error) {
 	start := time.Now()
 	defer func() {
 		result = strings.TrimSpace(result)
@@ -128,118 +198,417 @@ func (c *Client) Whois(domain string, servers ...string) (result string, err err
 		}
 	}()
 
+	r, err := c.QueryContext(ctx, domain, servers...)
+	if r != nil {
+		result = r.String()
+	}
+
+	return
+}
+
+// Query do the whois query and returns the structured result with every hop
+func (c *Client) Query(domain string, servers ...string) (*QueryResult, error) {
+	return c.QueryContext(context.Background(), domain, servers...)
+}
+
+// QueryContext do the whois query and returns the structured result with every hop,
+// the result is returned even if err is not nil, so that the failed hops can be inspected
+func (c *Client) QueryContext(ctx context.Context, domain string, servers ...string) (*QueryResult, error) {
+	result := &QueryResult{StartTime: time.Now()}
+	defer result.finish()
+
 	domain = strings.Trim(strings.TrimSpace(domain), ".")
 	if domain == "" {
-		return "", ErrDomainEmpty
+		return result, ErrDomainEmpty
 	}
 
-	isASN := IsASN(domain)
+	// ASN统一为AS123的格式
+	asn, isASN := parseASN(domain)
 	if isASN {
-		if !strings.HasPrefix(strings.ToUpper(domain), asnPrefix) {
-			domain = asnPrefix + domain
+		domain = asnPrefix + strconv.Itoa(asn)
+	}
+
+	// 将主机名缩减为可注册域名，如www.example.co.uk缩减为example.co.uk
+	domain = registrableDomain(domain)
+	result.Domain = domain
+
+	key := c.cacheKey(domain, servers...)
+	if c.cache != nil {
+		if v, ok := c.cache.Get(key); ok {
+			if entry, ok := v.(*whoisCacheEntry); ok {
+				start := result.StartTime
+				*result = *entry.result.clone()
+				result.StartTime, result.Cache = start, CacheHit
+				return result, entry.err
+			}
 		}
 	}
 
-	if !strings.Contains(domain, ".") && !strings.Contains(domain, ":") && !isASN {
-		return c.rawQuery(domain, defaultWhoisServer, defaultWhoisPort)
+	// 并发的相同查询只发起一次，共享查询结果，不同的服务器配置、连接方式和限制不共享
+	v, err, shared := lookupGroup.do(ctx, c.flightKey(key), func(ctx context.Context) (interface{}, error) {
+		r := &QueryResult{Domain: domain, StartTime: time.Now()}
+		err := c.lookup(ctx, r, domain, servers...)
+		r.finish()
+		c.logLookup(r, err)
+		// 被取消的查询不缓存
+		if c.cache != nil && ctx.Err() == nil {
+			ttl := c.cacheTTL.ttlOf(r.Final != nil && parsers.IsNotFound(r.Final.Response), err)
+			if r.Final != nil && hopFailure(*r.Final) == RetryLimitExceeded {
+				// 超出查询限制的结果按错误缓存
+				ttl = c.cacheTTL.Error
+			}
+			c.cache.Set(key, &whoisCacheEntry{result: r.clone(), err: err}, ttl)
+		}
+		return r, err
+	})
+	if v == nil {
+		return result, err
+	}
+
+	start := result.StartTime
+	*result = *v.(*QueryResult).clone()
+	result.StartTime, result.Shared = start, shared
+	if c.cache != nil {
+		result.Cache = CacheMiss
+	}
+
+	return result, err
+}
+
+// logLookup logs the result of a lookup
+func (c *Client) logLookup(r *QueryResult, err error) {
+	args := []interface{}{"query", r.Domain, "hops", len(r.Hops), "duration", r.Duration}
+	if r.Final != nil {
+		args = append(args, "server", r.Final.Server)
+	}
+	if r.Truncated {
+		args = append(args, "truncated", true)
+	}
+	if err != nil {
+		c.log().Warn("whois lookup failed", append(args, "error", err)...)
+		return
+	}
+	c.log().Debug("whois lookup", args...)
+}
+
+// cacheKey returns the key of the normalized query with the server and referral settings,
+// which is used by the cache and the coalescing of concurrent lookups.
+// the key of a client with its own server map is scoped to the map.
+func (c *Client) cacheKey(domain string, servers ...string) string {
+	server := ""
+	if len(servers) > 0 {
+		server = strings.ToLower(servers[0])
+	}
+
+	depth := c.maxReferralDepth
+	if c.disableReferral {
+		depth = 0
+	}
+
+	key := fmt.Sprintf("whois:%s@%s#%d", strings.ToLower(domain), server, depth)
+	// 默认配置保持原有的key，共享缓存的不同服务器配置互不影响
+	if c.serverMap != nil {
+		key += fmt.Sprintf("|%p", c.serverMap)
+	}
+
+	return key
+}
+
+// lookup do the whois query of the normalized domain, every hop is added to the result
+func (c *Client) lookup(ctx context.Context, result *QueryResult, domain string, servers ...string) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
 
-	var server, port string
+	if !strings.Contains(domain, ".") && !strings.Contains(domain, ":") && !IsASN(domain) {
+		hop := c.retryQuery(ctx, result, func() QueryHop {
+			return c.rawQuery(ctx, domain, defaultWhoisServer, defaultWhoisPort)
+		})
+		return hop.Err
+	}
+
+	var server, port, suffix string
 	if len(servers) > 0 && servers[0] != "" {
 		server = strings.ToLower(servers[0])
 		port = defaultWhoisPort
 	} else {
-		ext := getExtension(domain)
-		if v, ok := c.serverMap.GetWhoisServer(ext); ok {
-			// 如果tld存在于map中，更新server变量为map中对应的值
-			server = v
+		var err error
+		server, port, suffix, err = c.findServer(ctx, result, domain)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 主服务器失败时依次尝试配置的备用服务器
+	var hop QueryHop
+	candidates := append([]string{server}, c.servers().GetFallbackServers(suffix)...)
+	for i, candidate := range candidates {
+		if i > 0 {
 			port = defaultWhoisPort
-		} else {
-			result, err := c.rawQuery(ext, defaultWhoisServer, defaultWhoisPort)
-			if err != nil {
-				return "", fmt.Errorf("whois: query for whois server failed: %w", err)
-			}
-			server, port = getServer(result)
-			if server == "" {
-				return "", fmt.Errorf("%w: %s", ErrWhoisServerNotFound, domain)
+		}
+		hop = c.retryQuery(ctx, result, func() QueryHop {
+			return c.rawQuery(ctx, domain, candidate, port)
+		})
+		if hop.Err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if hop.Err != nil {
+		return hop.Err
+	}
+
+	if c.disableReferral {
+		return nil
+	}
+
+	// 逐跳跟随referral，按重写后的server/port检测循环
+	visited := map[string]bool{
+		net.JoinHostPort(hop.Server, hop.Port): true,
+	}
+	for depth := 0; depth < c.maxReferralDepth; depth++ {
+		refServer, refPort, rwhois := getReferral(hop.Response)
+		if refServer == "" {
+			break
+		}
+		key := net.JoinHostPort(c.rewriteServer(refServer), refPort)
+		if visited[key] {
+			break
+		}
+		visited[key] = true
+
+		refHop := c.retryQuery(ctx, result, func() QueryHop {
+			if rwhois {
+				return c.rwhoisQuery(ctx, domain, refServer, refPort)
 			}
-			// 将最新查询到的tld服务器存到map中
-			c.serverMap.SetWhoisServer(ext, server)
+			return c.rawQuery(ctx, domain, refServer, refPort)
+		})
+		// 后续referral失败时保留之前的结果
+		if refHop.Err != nil {
+			break
+		}
+		hop = refHop
+	}
+
+	return nil
+}
+
+// findServer returns the whois server, port and the matched suffix of the query,
+// ip is routed by the cidr table, domain by the longest suffix, otherwise discovered from IANA
+func (c *Client) findServer(ctx context.Context, result *QueryResult, domain string) (string, string, string, error) {
+	ext := getExtension(strings.ToLower(domain))
+
+	// IP和ASN不按单个地址缓存，避免map无限增长
+	cacheable := true
+	if ip := parseIPQuery(domain); ip != nil {
+		if server, ok := c.servers().GetIPWhoisServer(ip); ok {
+			return server, defaultWhoisPort, "", nil
 		}
+		ext, cacheable = ip.String(), false
+	} else if asn, ok := parseASN(domain); ok {
+		if server, ok := c.servers().GetASNWhoisServer(asn); ok {
+			return server, defaultWhoisPort, "", nil
+		}
+		ext, cacheable = domain, false
+	} else if suffix, server, ok := c.servers().LookupWhoisServer(domain); ok {
+		// 如果最长的后缀存在于map中，使用map中对应的值
+		return server, defaultWhoisPort, suffix, nil
 	}
 
-	result, err = c.rawQuery(domain, server, port)
+	hop := c.retryQuery(ctx, result, func() QueryHop {
+		hop := c.rawQuery(ctx, ext, defaultWhoisServer, defaultWhoisPort)
+		hop.Discovery = true
+		return hop
+	})
+	if hop.Err != nil {
+		return "", "", "", fmt.Errorf("whois: query for whois server failed: %w", hop.Err)
+	}
+
+	server, port := getServer(hop.Response)
+	if server == "" {
+		return "", "", "", fmt.Errorf("%w: %s", ErrWhoisServerNotFound, domain)
+	}
+
+	// 将最新查询到的tld服务器存到map中
+	if cacheable {
+		c.servers().SetWhoisServer(ext, server)
+		c.log().Debug("whois server discovered", "query", domain, "suffix", ext, "server", server)
+	}
+
+	return server, port, ext, nil
+}
+
+// rawQuery do raw query to the server and returns the hop
+func (c *Client) rawQuery(ctx context.Context, domain, server, port string) (hop QueryHop) {
+	start := time.Now()
+	defer func() {
+		hop.TotalTime = time.Since(start)
+	}()
+
+	hop, options := c.newHop(domain, server, port)
+
+	done, err := c.waitLimiter(ctx, &hop, options)
 	if err != nil {
 		return
 	}
+	defer func() {
+		done(hop)
+	}()
 
-	if c.disableReferral {
+	ctx, cancel := c.hopContext(ctx, options)
+	defer cancel()
+
+	conn, err := c.transport.DialContext(ctx, "tcp", net.JoinHostPort(hop.Server, hop.Port))
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		hop.Err = fmt.Errorf("whois: connect to whois server (%s) failed: %w", hop.Server, err)
+		hop.failure = RetryConnect
 		return
 	}
 
-	refServer, refPort := getServer(result)
-	if refServer == "" || refServer == server {
+	defer conn.Close()
+	hop.ConnectTime = time.Since(start)
+	setDeadline(ctx, conn)
+
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	_, err = conn.Write([]byte(hop.Query + "\r\n"))
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		hop.Err = fmt.Errorf("whois: send to whois server (%s) failed: %w", hop.Server, err)
+		hop.failure = ioFailure(err)
 		return
 	}
 
-	data, err := c.rawQuery(domain, refServer, refPort)
-	if err == nil {
-		result += data
+	reader := &firstByteReader{reader: conn, start: start}
+	buffer, truncated, err := readResponse(reader, c.maxResponseSize)
+	hop.FirstByteTime = reader.elapsed
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		err = fmt.Errorf("whois: read from whois server (%s) failed: %w", hop.Server, err)
+		hop.failure = ioFailure(err)
+		if len(buffer) == 0 {
+			hop.Err = err
+			return
+		}
+		// 保留已读取的部分数据，标记为截断
+		hop.Error = err.Error()
+		truncated = true
 	}
+	hop.Truncated = truncated
+
+	// 按配置或自动检测的字符集转换为utf-8
+	hop.Response, hop.Charset = decodeResponse(buffer, options.Charset, domain, truncated)
+	hop.Bytes = len(buffer)
 
 	return
 }
 
-// rawQuery do raw query to the server
-func (c *Client) rawQuery(domain, server, port string) (string, error) {
-	c.elapsed = 0
-	// start := time.Now()
-	if server == "whois.arin.net" {
-		if IsASN(domain) {
-			domain = "a + " + domain
-		} else {
-			domain = "n + " + domain
-		}
+// readResponse reads the response until EOF or limit bytes, limit not greater than 0 means no limit,
+// truncated is true if the response is larger than limit, the data read is returned even if err is not nil
+func readResponse(r io.Reader, limit int64) ([]byte, bool, error) {
+	if limit <= 0 {
+		data, err := io.ReadAll(r)
+		return data, false, err
 	}
 
-	if value, ok := c.serverMap.GetRewriteServer(server); ok {
-		// 如果键存在于map中，更新server变量为map中对应的值
-		server = value
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(data)) > limit {
+		return data[:limit], true, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
-	defer cancel()
+	return data, false, err
+}
 
-	// conn, err := c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(server, port))
-	conn, err := dialContext(ctx, c.dialer, "tcp", net.JoinHostPort(server, port))
-	if err != nil {
-		return "", fmt.Errorf("whois: connect to whois server (%s) failed: %w", server, err)
+// newHop returns the hop with the server rewrite and the server options applied
+func (c *Client) newHop(domain, server, port string) (QueryHop, ServerOptions) {
+	server = c.rewriteServer(server)
+
+	options, _ := c.servers().GetServerOptions(server)
+	if options.Port != "" && port == defaultWhoisPort {
+		port = options.Port
 	}
 
-	defer conn.Close()
-	// c.elapsed = time.Since(start)
+	return QueryHop{
+		Server: server,
+		Port:   port,
+		Query:  options.FormatQuery(domain),
+	}, options
+}
 
-	// _ = conn.SetWriteDeadline(time.Now().Add(c.timeout - c.elapsed))
-	_, err = conn.Write([]byte(domain + "\r\n"))
-	if err != nil {
-		return "", fmt.Errorf("whois: send to whois server (%s) failed: %w", server, err)
+// hopContext returns the context of a hop, which is bounded by the server timeout if set,
+// the lookup timeout is applied to ctx already
+func (c *Client) hopContext(ctx context.Context, options ServerOptions) (context.Context, context.CancelFunc) {
+	if options.Timeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(options.Timeout))
 	}
+	return context.WithCancel(ctx)
+}
 
-	// c.elapsed = time.Since(start)
+// setDeadline sets the read and write deadline of the conn to the ctx deadline
+func setDeadline(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+}
 
-	// _ = conn.SetReadDeadline(time.Now().Add(c.timeout - c.elapsed))
-	buffer, err := io.ReadAll(conn)
-	if err != nil {
-		return "", fmt.Errorf("whois: read from whois server (%s) failed: %w", server, err)
+// closeOnDone closes the conn if ctx is done to interrupt the blocking read and write,
+// the returned func must be called after the conn is no longer used
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
 	}
+}
 
-	// c.elapsed = time.Since(start)
+// rewriteServer returns the rewritten server if exists
+func (c *Client) rewriteServer(server string) string {
+	if value, ok := c.servers().GetRewriteServer(server); ok {
+		// 如果键存在于map中，更新server变量为map中对应的值
+		return value
+	}
+	return server
+}
 
-	return string(buffer), nil
+// firstByteReader records the time elapsed until the first byte is read
+type firstByteReader struct {
+	reader  io.Reader
+	start   time.Time
+	elapsed time.Duration
+}
+
+// Read implements io.Reader
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 && r.elapsed == 0 {
+		r.elapsed = time.Since(r.start)
+	}
+	return n, err
 }
 
 // getServer returns server from whois data
 func getServer(data string) (string, string) {
+	server, port, _ := getReferral(data)
+	return server, port
+}
+
+// getReferral returns server, port and whether the server speaks rwhois from whois data
+func getReferral(data string) (string, string, bool) {
 	tokens := []string{
 		"Registrar WHOIS Server: ",
 		"whois: ",
@@ -256,55 +625,107 @@ func getServer(data string) (string, string) {
 				end = len(data[start:])
 			}
 			server := strings.TrimSpace(data[start : start+end])
+			rwhois := strings.HasPrefix(strings.ToLower(server), rwhoisScheme)
 
 			// 新增代码：从URL提取主机名
 			server = extractHostname(server)
 
 			port := defaultWhoisPort
+			if rwhois {
+				port = defaultRWhoisPort
+			}
 			if strings.Contains(server, ":") {
 				v := strings.Split(server, ":")
 				server, port = v[0], v[1]
 			}
-			return server, port
+			return server, port, rwhois
 		}
 	}
 
-	return "", ""
+	return "", "", false
 }
 
-// dialContext 尝试使用给定的代理Dialer和context来建立连接
+// dialContext connects to the address by the dialer, the DialContext of the dialer is used if implemented,
+// otherwise the dial runs in background and the conn is closed if ctx is done before it returns
 func dialContext(ctx context.Context, dialer proxy.Dialer, network, addr string) (net.Conn, error) {
-	// 注意：这里仅为示例，实际上golang.org/x/net/proxy包的Dialer可能不直接支持context。
-	// 如果你的代理Dialer支持DialContext，直接使用它。
-	// 否则，你需要根据具体的Dialer实现调整此函数。
-	ch := make(chan net.Conn, 1)
-	var dialErr error
+	if d, ok := dialer.(proxy.ContextDialer); ok {
+		return d.DialContext(ctx, network, addr)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan dialResult, 1)
 	go func() {
 		conn, err := dialer.Dial(network, addr)
-		if err != nil {
-			dialErr = err
-			ch <- nil
-			return
-		}
-		ch <- conn
+		ch <- dialResult{conn: conn, err: err}
 	}()
 
 	select {
-	case conn := <-ch:
-		return conn, dialErr
+	case r := <-ch:
+		return r.conn, r.err
 	case <-ctx.Done():
+		// 拨号返回后关闭不再使用的连接，避免泄漏
+		go func() {
+			if r := <-ch; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
 		return nil, ctx.Err()
 	}
 }
 
-// sync.Once的作用是确保在多线程环境下一个操作只被执行一次
-func InitWhois(configFile string) {
-	onceWhois.Do(func() {
-		serverMapInstance = NewServerMap()
-		err := serverMapInstance.LoadFromFile(configFile)
-		if err != nil {
-			// Handle error, e.g., log it, panic, etc.
+// servers returns the server map of the client, the default server map if not set
+func (c *Client) servers() *ServerMap {
+	if c.serverMap != nil {
+		return c.serverMap
+	}
+
+	return defaultServerMap()
+}
+
+// defaultServerMap returns the server map loaded by InitWhois, the embedded config is loaded if not initialized
+func defaultServerMap() *ServerMap {
+	if sm := serverMapInstance.Load(); sm != nil {
+		return sm
+	}
+
+	serverMapMu.Lock()
+	defer serverMapMu.Unlock()
+
+	sm := serverMapInstance.Load()
+	if sm == nil {
+		sm = NewServerMap()
+		if err := sm.LoadFromFile(""); err != nil {
 			panic(err)
 		}
-	})
+		serverMapInstance.Store(sm)
+	}
+
+	return sm
+}
+
+// InitWhois loads the default server map from the config file, the embedded config is used if empty.
+// only the first call takes effect, it replaces the embedded config loaded by the queries before it,
+// and keeps the servers learned from IANA. it panics if the config file is invalid.
+func InitWhois(configFile string) {
+	serverMapMu.Lock()
+	defer serverMapMu.Unlock()
+
+	if serverMapInited {
+		return
+	}
+	serverMapInited = true
+
+	// 已被查询懒加载内置配置时在原map上替换配置
+	sm := serverMapInstance.Load()
+	if sm == nil {
+		sm = NewServerMap()
+	}
+	if err := sm.LoadFromFile(configFile); err != nil {
+		panic(err)
+	}
+	serverMapInstance.Store(sm)
 }
@@ -5,8 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"net"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/yl2chen/cidranger"
 )
 
 //go:embed config/servers.json
@@ -15,78 +21,362 @@ var embeddedServerFiles embed.FS
 type ServerConfig struct {
 	Rewrite map[string]string `json:"rewrite"`
 	Servers map[string]string `json:"servers"`
+	IP      map[string]string `json:"ip,omitempty"`
+	ASN     map[string]string `json:"asn,omitempty"`
+	// Fallbacks 为后缀配置备用服务器，主服务器失败时依次尝试
+	Fallbacks map[string][]string      `json:"fallbacks,omitempty"`
+	Options   map[string]ServerOptions `json:"options,omitempty"`
+	// ipRanger 由IP中的CIDR构建，用于IP查询直接路由到RIR
+	ipRanger cidranger.Ranger
+	// asnRanges 由ASN中的范围构建，按Start排序，用于ASN查询直接路由到RIR
+	asnRanges []asnServerRange
+}
+
+// asnServerRange 表示ASN范围和对应的whois服务器
+type asnServerRange struct {
+	Start  int
+	End    int
+	Server string
+}
+
+// queryPlaceholder is replaced by the query string in query templates
+const queryPlaceholder = "{query}"
+
+// ServerOptions is the per-server query settings, keyed by the server host in ServerConfig.Options
+type ServerOptions struct {
+	// Query is the query template, such as "-T dn,ace {query}",
+	// the query is appended if the template has no {query}
+	Query string `json:"query,omitempty"`
+	// ASNQuery is the query template for ASN queries, Query is used if empty
+	ASNQuery string `json:"asn_query,omitempty"`
+	// Port is the server port, used when the referral does not specify one
+	Port string `json:"port,omitempty"`
+	// Charset is the response charset, such as "gbk" or "euc-jp"
+	Charset string `json:"charset,omitempty"`
+	// Timeout is the query timeout of the server, such as "10s"
+	Timeout Duration `json:"timeout,omitempty"`
+	// RateLimit is the request budget of the server, the limiter defaults if nil
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// builtinServerOptions are used if the server is not in the config options
+var builtinServerOptions = map[string]ServerOptions{
+	"whois.arin.net": {Query: "n + {query}", ASNQuery: "a + {query}"},
+}
+
+// FormatQuery returns the query string to send by the template
+func (o ServerOptions) FormatQuery(query string) string {
+	template := o.Query
+	if o.ASNQuery != "" && IsASN(query) {
+		template = o.ASNQuery
+	}
+
+	if template == "" {
+		return query
+	}
+
+	if !strings.Contains(template, queryPlaceholder) {
+		return template + query
+	}
+
+	return strings.ReplaceAll(template, queryPlaceholder, query)
 }
 
-type serverMap struct {
+// Duration is time.Duration which unmarshals from "10s" or a number of seconds
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("whois: invalid duration %q: %w", value, err)
+		}
+		*d = Duration(duration)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("whois: invalid duration %s", string(data))
+	}
+
+	return nil
+}
+
+// ServerMap is the whois servers of the suffixes, ips and asns, it is safe for concurrent use
+type ServerMap struct {
 	sync.RWMutex
 	config *ServerConfig
+	// filename 是最后加载的配置文件，为空时使用嵌入的配置，重新加载时使用
+	filename string
+	// learned 保存从IANA发现的服务器，与配置文件分开保存，重新加载配置时保留
+	learned     map[string]LearnedServer
+	learnedFile string
+	learnedTTL  time.Duration
+	learnedMu   sync.Mutex
 }
 
-// NewServerMap creates a new serverMap instance
-func NewServerMap() *serverMap {
-	return &serverMap{
+// NewServerMap creates a new ServerMap instance
+func NewServerMap() *ServerMap {
+	return &ServerMap{
 		// 初始化ServerConfig结构体，其中包括Servers和Rewrite的map
 		config: &ServerConfig{
-			Rewrite: make(map[string]string),
-			Servers: make(map[string]string),
+			Rewrite:   make(map[string]string),
+			Servers:   make(map[string]string),
+			IP:        make(map[string]string),
+			ASN:       make(map[string]string),
+			Fallbacks: make(map[string][]string),
+			Options:   make(map[string]ServerOptions),
+			ipRanger:  cidranger.NewPCTrieRanger(),
+			asnRanges: make([]asnServerRange, 0),
 		},
+		learned: make(map[string]LearnedServer),
 	}
 }
 
 // GetWhoisServer returns the WHOIS server for the given TLD
 // 获取whois服务器
-func (sm *serverMap) GetWhoisServer(tld string) (string, bool) {
+func (sm *ServerMap) GetWhoisServer(tld string) (string, bool) {
+	sm.RLock()
+	defer sm.RUnlock()
+	if server, exists := sm.config.Servers[tld]; exists {
+		return server, true
+	}
+	return sm.getLearned(tld)
+}
+
+// LookupWhoisServer returns the longest suffix of the domain which has a whois server,
+// such as co.uk before uk for example.co.uk
+func (sm *ServerMap) LookupWhoisServer(domain string) (string, string, bool) {
+	sm.RLock()
+	defer sm.RUnlock()
+	for _, suffix := range domainSuffixes(domain) {
+		if server, exists := sm.config.Servers[suffix]; exists {
+			return suffix, server, true
+		}
+		if server, exists := sm.getLearned(suffix); exists {
+			return suffix, server, true
+		}
+	}
+	return "", "", false
+}
+
+// GetIPWhoisServer returns the WHOIS server of the most specific network containing the ip
+func (sm *ServerMap) GetIPWhoisServer(ip net.IP) (string, bool) {
 	sm.RLock()
 	defer sm.RUnlock()
-	server, exists := sm.config.Servers[tld]
+	entries, err := sm.config.ipRanger.ContainingNetworks(ip)
+	if err != nil || len(entries) == 0 {
+		return "", false
+	}
+	network := entries[len(entries)-1].Network()
+	server, exists := sm.config.IP[network.String()]
 	return server, exists
 }
 
-// 设置whois服务器
-func (sm *serverMap) SetWhoisServer(tld string, server string) (string, bool) {
-	// 写入map中，需要先获取写锁
-	sm.Lock()
-	defer sm.Unlock()
-	// 再次检查是否存在，以避免多线程查询后重复写入
-	if _, exists := sm.config.Servers[tld]; !exists {
-		sm.config.Servers[tld] = server
+// GetASNWhoisServer returns the WHOIS server of the range containing the asn
+func (sm *ServerMap) GetASNWhoisServer(asn int) (string, bool) {
+	sm.RLock()
+	defer sm.RUnlock()
+	ranges := sm.config.asnRanges
+	index := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].End >= asn
+	})
+	if index < len(ranges) && ranges[index].Start <= asn {
+		return ranges[index].Server, true
+	}
+	return "", false
+}
+
+// GetFallbackServers returns the fallback servers of the suffix
+func (sm *ServerMap) GetFallbackServers(suffix string) []string {
+	if suffix == "" {
+		return nil
 	}
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.config.Fallbacks[suffix]
+}
+
+// 设置whois服务器，作为学习到的服务器保存，启用持久化时写入文件
+func (sm *ServerMap) SetWhoisServer(tld string, server string) (string, bool) {
+	sm.learnServer(tld, server)
 	return server, true
 }
 
 // 获取重写服务
-func (sm *serverMap) GetRewriteServer(server string) (string, bool) {
+func (sm *ServerMap) GetRewriteServer(server string) (string, bool) {
 	sm.RLock()
 	defer sm.RUnlock()
 	server, exists := sm.config.Rewrite[server]
 	return server, exists
 }
 
-// LoadFromFile loads the server map from a JSON file
-func (sm *serverMap) LoadFromFile(filename string) error {
+// GetServerOptions returns the query options of the server
+func (sm *ServerMap) GetServerOptions(server string) (ServerOptions, bool) {
+	sm.RLock()
+	options, exists := sm.config.Options[server]
+	sm.RUnlock()
+	if exists {
+		return options, true
+	}
+
+	options, exists = builtinServerOptions[server]
+	return options, exists
+}
+
+// LoadFromFile loads the server map from a JSON file, the embedded config is used if filename is empty.
+// the config is validated before replacing the current one, which is kept on error.
+func (sm *ServerMap) LoadFromFile(filename string) error {
+	config, err := loadServerConfig(filename)
+	if err != nil {
+		return err
+	}
+
+	sm.setConfig(config, filename)
+
+	return nil
+}
+
+// Reload reloads the config from the file loaded last time, the current config is kept on error
+func (sm *ServerMap) Reload() error {
+	return sm.LoadFromFile(sm.Filename())
+}
+
+// setConfig replaces the config and the file name, the learned servers are kept
+func (sm *ServerMap) setConfig(config *ServerConfig, filename string) {
+	sm.Lock()
+	sm.config = config
+	sm.filename = filename
+	sm.Unlock()
+
+	getLogger().Info("whois config loaded", "source", configSourceName(filename),
+		"servers", len(config.Servers), "ip", len(config.IP), "asn", len(config.ASN))
+}
+
+// configSourceName returns the name of the config source in the logs
+func configSourceName(source string) string {
+	if source == "" {
+		return "embedded"
+	}
+
+	return source
+}
+
+// Filename returns the config file loaded last time, empty if the embedded config is used
+func (sm *ServerMap) Filename() string {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.filename
+}
+
+// loadServerConfig reads and validates the server config from the file or the embedded config
+func loadServerConfig(filename string) (*ServerConfig, error) {
 	var data []byte
 	var err error
 
 	if filename != "" {
 		// 使用os.ReadFile读取文件
 		data, err = os.ReadFile(filename)
-		fmt.Printf("从指定路径 %s 读取配置", filename)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		// 从嵌入的文件系统读取
 		data, err = fs.ReadFile(embeddedServerFiles, "config/servers.json")
-		fmt.Println("从默认路径读取servers.json")
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	sm.Lock()
-	defer sm.Unlock()
-
 	// 使用新的ServerConfig结构体来解析数据
-	sm.config = &ServerConfig{}
-	return json.Unmarshal(data, sm.config)
+	config := &ServerConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	// 旧的配置文件中没有的字段，初始化为空map，避免写入时panic
+	if config.Rewrite == nil {
+		config.Rewrite = make(map[string]string)
+	}
+	if config.Servers == nil {
+		config.Servers = make(map[string]string)
+	}
+	if config.IP == nil {
+		config.IP = make(map[string]string)
+	}
+	if config.ASN == nil {
+		config.ASN = make(map[string]string)
+	}
+	if config.Fallbacks == nil {
+		config.Fallbacks = make(map[string][]string)
+	}
+	if config.Options == nil {
+		config.Options = make(map[string]ServerOptions)
+	}
+
+	// 服务器不能为空，避免替换为无法查询的配置
+	for suffix, server := range config.Servers {
+		if strings.TrimSpace(server) == "" {
+			return nil, fmt.Errorf("whois: empty server of %q", suffix)
+		}
+	}
+
+	// 构建IP Ranger，key统一为规范的CIDR格式
+	ip := make(map[string]string, len(config.IP))
+	config.ipRanger = cidranger.NewPCTrieRanger()
+	for cidr, server := range config.IP {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("whois: invalid cidr %q: %w", cidr, err)
+		}
+		if err := config.ipRanger.Insert(cidranger.NewBasicRangerEntry(*network)); err != nil {
+			return nil, fmt.Errorf("whois: add cidr %q failed: %w", cidr, err)
+		}
+		ip[network.String()] = server
+	}
+	config.IP = ip
+
+	// 构建ASN范围，按Start排序，以便进行二分查找
+	config.asnRanges = make([]asnServerRange, 0, len(config.ASN))
+	for rangeStr, server := range config.ASN {
+		asnRange, err := NewASNRange(rangeStr, server)
+		if err != nil {
+			return nil, fmt.Errorf("whois: invalid asn range %q: %w", rangeStr, err)
+		}
+		if asnRange.Start > asnRange.End {
+			return nil, fmt.Errorf("whois: invalid asn range %q: start is greater than end", rangeStr)
+		}
+		config.asnRanges = append(config.asnRanges, asnServerRange{
+			Start:  asnRange.Start,
+			End:    asnRange.End,
+			Server: server,
+		})
+	}
+	sort.Slice(config.asnRanges, func(i, j int) bool {
+		return config.asnRanges[i].Start < config.asnRanges[j].Start
+	})
+
+	// 二分查找要求范围不重叠，重叠的范围会路由到错误的服务器
+	for i := 1; i < len(config.asnRanges); i++ {
+		prev, cur := config.asnRanges[i-1], config.asnRanges[i]
+		if cur.Start <= prev.End {
+			return nil, fmt.Errorf("whois: asn range %d-%d of %s overlaps %d-%d of %s",
+				cur.Start, cur.End, cur.Server, prev.Start, prev.End, prev.Server)
+		}
+	}
+
+	return config, nil
 }
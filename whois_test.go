@@ -20,18 +20,37 @@
 package whois
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/likexian/gokit/assert"
 	"golang.org/x/net/proxy"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
 
 	parsers "github.com/darkqiank/whois/parsers"
+	"github.com/darkqiank/whois/whoistest"
 )
 
 func TestVersion(t *testing.T) {
@@ -196,3 +215,1770 @@ func TestIsASN(t *testing.T) {
 		assert.Equal(t, IsASN(v.in), v.out)
 	}
 }
+
+func TestClient_WhoisContextCancel(t *testing.T) {
+	InitWhois("config/test.json")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// 不返回任何数据，直到连接被关闭
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	start := time.Now()
+	hop := NewClient().rawQuery(ctx, "likexian.com", "127.0.0.1", port)
+	assert.NotNil(t, hop.Err)
+	assert.True(t, errors.Is(hop.Err, context.Canceled))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRDAPClient_RDAPContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := NewRDAPClient().rdapRawQuery(ctx, srv.URL)
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestQueryResult(t *testing.T) {
+	result := &QueryResult{StartTime: time.Now()}
+	result.addHop(QueryHop{Server: "whois.iana.org", Response: "refer: whois.verisign-grs.com\n", Discovery: true})
+	result.addHop(QueryHop{Server: "whois.verisign-grs.com", Response: "registry\n"})
+	result.addHop(QueryHop{Server: "whois.markmonitor.com", Err: errors.New("whois: read failed")})
+	result.finish()
+
+	assert.Equal(t, result.String(), "registry\n")
+	assert.NotNil(t, result.Final)
+	assert.Equal(t, result.Final.Server, "whois.verisign-grs.com")
+	assert.Equal(t, result.Hops[2].Error, "whois: read failed")
+
+	result.addHop(QueryHop{Server: "whois.markmonitor.com", Response: "registrar\n"})
+	result.finish()
+	assert.Equal(t, result.String(), "registry\nregistrar\n")
+	assert.Equal(t, result.Final.Server, "whois.markmonitor.com")
+}
+
+func TestServerOptions(t *testing.T) {
+	tests := []struct {
+		options ServerOptions
+		query   string
+		out     string
+	}{
+		{ServerOptions{}, "likexian.com", "likexian.com"},
+		{ServerOptions{Query: "-T dn,ace {query}"}, "likexian.de", "-T dn,ace likexian.de"},
+		{ServerOptions{Query: "{query}/e"}, "likexian.jp", "likexian.jp/e"},
+		{ServerOptions{Query: "domain "}, "likexian.com", "domain likexian.com"},
+		{ServerOptions{Query: "n + {query}", ASNQuery: "a + {query}"}, "1.1.1.1", "n + 1.1.1.1"},
+		{ServerOptions{Query: "n + {query}", ASNQuery: "a + {query}"}, "AS123", "a + AS123"},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, v.options.FormatQuery(v.query), v.out)
+	}
+
+	var config ServerConfig
+	err := json.Unmarshal([]byte(`{"servers": {"de": "whois.denic.de"},
+		"options": {"whois.denic.de": {"query": "-T dn,ace {query}", "port": "4343", "timeout": "3s"},
+		"whois.jprs.jp": {"timeout": 5}}}`), &config)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Options["whois.denic.de"].Port, "4343")
+	assert.Equal(t, time.Duration(config.Options["whois.denic.de"].Timeout), 3*time.Second)
+	assert.Equal(t, time.Duration(config.Options["whois.jprs.jp"].Timeout), 5*time.Second)
+
+	sm := NewServerMap()
+	err = sm.LoadFromFile("config/servers.json")
+	assert.Nil(t, err)
+	options, ok := sm.GetServerOptions("whois.denic.de")
+	assert.True(t, ok)
+	assert.Equal(t, options.FormatQuery("likexian.de"), "-T dn,ace likexian.de")
+
+	sm = NewServerMap()
+	err = sm.LoadFromFile("config/test.json")
+	assert.Nil(t, err)
+	options, ok = sm.GetServerOptions("whois.arin.net")
+	assert.True(t, ok)
+	assert.Equal(t, options.FormatQuery("AS123"), "a + AS123")
+}
+
+func TestDecodeResponse(t *testing.T) {
+	encode := func(enc encoding.Encoding, text string) []byte {
+		data, err := enc.NewEncoder().Bytes([]byte(text))
+		assert.Nil(t, err)
+		return data
+	}
+
+	tests := []struct {
+		data    []byte
+		charset string
+		domain  string
+		text    string
+		name    string
+	}{
+		{[]byte("Domain Name: likexian.com\n"), "", "likexian.com", "Domain Name: likexian.com\n", "utf-8"},
+		{[]byte("注册者: 李科贤\n"), "", "likexian.cn", "注册者: 李科贤\n", "utf-8"},
+		{encode(simplifiedchinese.GBK, "注册者: 北京某某科技有限公司\n"), "", "likexian.cn", "注册者: 北京某某科技有限公司\n", "gb18030"},
+		{encode(simplifiedchinese.GBK, "注册者: 北京某某科技有限公司\n"), "gbk", "likexian.com", "注册者: 北京某某科技有限公司\n", "gbk"},
+		{encode(traditionalchinese.Big5, "註冊人: 台灣網路資訊中心\n"), "", "likexian.tw", "註冊人: 台灣網路資訊中心\n", "big5"},
+		{encode(japanese.ShiftJIS, "登録者: 日本レジストリサービス\n"), "", "likexian.jp", "登録者: 日本レジストリサービス\n", "shift_jis"},
+		{encode(japanese.EUCJP, "登録者: 日本レジストリサービス\n"), "euc-jp", "likexian.jp", "登録者: 日本レジストリサービス\n", "euc-jp"},
+		{encode(charmap.KOI8R, "Администратор домена\n"), "", "likexian.ru", "Администратор домена\n", "koi8-r"},
+		{encode(charmap.Windows1251, "Администратор домена\n"), "", "likexian.ru", "Администратор домена\n", "windows-1251"},
+		{encode(charmap.ISO8859_1, "Société Générale, Zürich\n"), "", "likexian.fr", "Société Générale, Zürich\n", "iso-8859-1"},
+	}
+
+	for _, v := range tests {
+		text, name := decodeResponse(v.data, v.charset, v.domain, false)
+		assert.Equal(t, text, v.text)
+		assert.Equal(t, name, v.name)
+	}
+
+	// 截断切开的多字节字符被去掉，仍按utf-8解码
+	data := []byte("注册者: 李科贤")
+	text, name := decodeResponse(data[:len(data)-1], "", "likexian.cn", true)
+	assert.Equal(t, text, "注册者: 李科")
+	assert.Equal(t, name, "utf-8")
+	text, name = decodeResponse(data[:len(data)-2], "", "likexian.cn", true)
+	assert.Equal(t, text, "注册者: 李科")
+	assert.Equal(t, name, "utf-8")
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"likexian.com", "likexian.com"},
+		{"www.likexian.com", "likexian.com"},
+		{"www.example.co.uk", "example.co.uk"},
+		{"a.b.example.com.cn", "example.com.cn"},
+		{"www.example.ac.jp", "example.ac.jp"},
+		{"foo.github.io", "github.io"},
+		{"co.uk", "co.uk"},
+		{"1.1.1.1", "1.1.1.1"},
+		{"AS123", "AS123"},
+		{"likexian.jp?e", "likexian.jp?e"},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, registrableDomain(v.in), v.out)
+	}
+
+	sm := NewServerMap()
+	sm.SetWhoisServer("uk", "whois.nic.uk")
+	sm.SetWhoisServer("co.uk", "whois.co.uk.example")
+	suffix, server, ok := sm.LookupWhoisServer("example.co.uk")
+	assert.True(t, ok)
+	assert.Equal(t, suffix, "co.uk")
+	assert.Equal(t, server, "whois.co.uk.example")
+	suffix, _, ok = sm.LookupWhoisServer("example.org.uk")
+	assert.True(t, ok)
+	assert.Equal(t, suffix, "uk")
+	_, _, ok = sm.LookupWhoisServer("example.com")
+	assert.False(t, ok)
+
+	filename := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+	err := os.WriteFile(filename, []byte("// ===BEGIN ICANN DOMAINS===\nuk\nco.uk\n*.ck\n!www.ck\n"+
+		"// ===BEGIN PRIVATE DOMAINS===\nblogspot.co.uk\n"), 0o600)
+	assert.Nil(t, err)
+	err = LoadPublicSuffixList(filename)
+	assert.Nil(t, err)
+	defer func() {
+		_ = LoadPublicSuffixList("")
+	}()
+
+	assert.Equal(t, registrableDomain("www.example.co.uk"), "example.co.uk")
+	assert.Equal(t, registrableDomain("foo.blogspot.co.uk"), "blogspot.co.uk")
+	assert.Equal(t, registrableDomain("a.b.example.ck"), "b.example.ck")
+	assert.Equal(t, registrableDomain("a.www.ck"), "www.ck")
+}
+
+func TestGetIPWhoisServer(t *testing.T) {
+	sm := NewServerMap()
+	err := sm.LoadFromFile("config/servers.json")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"1.1.1.1", "whois.apnic.net"},
+		{"8.8.8.8", "whois.arin.net"},
+		{"193.0.6.139", "whois.ripe.net"},
+		{"200.160.2.3", "whois.lacnic.net"},
+		{"41.0.0.1", "whois.afrinic.net"},
+		{"2001:4860:4860::8888", "whois.arin.net"},
+		{"2a00:1450::1", "whois.ripe.net"},
+		{"1.1.1.0/24", "whois.apnic.net"},
+	}
+
+	for _, v := range tests {
+		server, ok := sm.GetIPWhoisServer(parseIPQuery(v.in))
+		assert.True(t, ok)
+		assert.Equal(t, server, v.out)
+	}
+
+	_, ok := sm.GetIPWhoisServer(net.ParseIP("10.0.0.1"))
+	assert.False(t, ok)
+	assert.True(t, parseIPQuery("likexian.com") == nil)
+
+	sm = NewServerMap()
+	err = sm.LoadFromFile("config/test.json")
+	assert.Nil(t, err)
+	_, ok = sm.GetIPWhoisServer(net.ParseIP("1.1.1.1"))
+	assert.False(t, ok)
+}
+
+func TestGetASNWhoisServer(t *testing.T) {
+	sm := NewServerMap()
+	err := sm.LoadFromFile("config/servers.json")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"AS1", "whois.arin.net"},
+		{"as3333", "whois.ripe.net"},
+		{"ASN4608", "whois.apnic.net"},
+		{"28000", "whois.lacnic.net"},
+		{"AS37100", "whois.afrinic.net"},
+		{"AS13335", "whois.arin.net"},
+		{"AS327700", "whois.afrinic.net"},
+		{"AS4200000000", ""},
+	}
+
+	for _, v := range tests {
+		asn, ok := parseASN(v.in)
+		assert.True(t, ok)
+		server, ok := sm.GetASNWhoisServer(asn)
+		assert.Equal(t, ok, v.out != "")
+		assert.Equal(t, server, v.out)
+	}
+
+	_, ok := parseASN("likexian.com")
+	assert.False(t, ok)
+
+	options, ok := sm.GetServerOptions("whois.arin.net")
+	assert.True(t, ok)
+	assert.Equal(t, options.FormatQuery("AS13335"), "a + AS13335")
+
+	// 重叠或无效的ASN范围被拒绝，保留原配置
+	dir := t.TempDir()
+	for i, data := range []string{
+		`{"asn": {"1-100": "whois.one.fake", "100-200": "whois.two.fake"}}`,
+		`{"asn": {"1-100": "whois.one.fake", "50": "whois.two.fake"}}`,
+		`{"asn": {"200-100": "whois.one.fake"}}`,
+	} {
+		filename := filepath.Join(dir, fmt.Sprintf("servers%d.json", i))
+		assert.Nil(t, os.WriteFile(filename, []byte(data), 0644))
+		assert.NotNil(t, sm.LoadFromFile(filename))
+	}
+	server, _ := sm.GetASNWhoisServer(1)
+	assert.Equal(t, server, "whois.arin.net")
+}
+
+func TestClient_RWhoisQuery(t *testing.T) {
+	InitWhois("config/test.json")
+
+	srv := whoistest.NewServer()
+	defer srv.Close()
+	srv.HandleConnect("rwhois.example.net:4321",
+		whoistest.Text("%rwhois V-1.5:003fff:00 rwhois.example.net (by Network Solutions, Inc. V-1.5.9.5)\r\n"))
+	srv.HandleText("rwhois.example.net:4321", "-holdconnect on", "%ok\r\n")
+	srv.HandleText("rwhois.example.net:4321", "-quit", "%ok\r\n")
+	srv.HandleText("rwhois.example.net:4321", "192.0.2.1", "network:Class-Name:network\r\nnetwork:Network-Name:EXAMPLE-NET\r\n%ok\r\n")
+	srv.HandleText("rwhois.example.net:4321", "", "%error 230 No Objects Found\r\n")
+
+	c := NewClient(WithTransport(srv))
+	port := "4321"
+
+	hop := c.rwhoisQuery(context.Background(), "192.0.2.1", "rwhois.example.net", port)
+	assert.Nil(t, hop.Err)
+	assert.Equal(t, hop.Response, "network:Class-Name:network\nnetwork:Network-Name:EXAMPLE-NET\n")
+
+	hop = c.rwhoisQuery(context.Background(), "192.0.2.2", "rwhois.example.net", port)
+	assert.NotNil(t, hop.Err)
+	var rerr *RWhoisError
+	assert.True(t, errors.As(hop.Err, &rerr))
+	assert.Equal(t, rerr.Code, 230)
+	assert.Equal(t, rerr.Message, "No Objects Found")
+
+	// 不是rwhois协议的服务器按读取失败处理，而不是连接失败
+	srv.HandleConnect("whois.example.net:4321", whoistest.Text("Welcome to whois\r\n"))
+	hop = c.rwhoisQuery(context.Background(), "192.0.2.1", "whois.example.net", port)
+	assert.True(t, errors.Is(hop.Err, ErrRWhoisBanner))
+	assert.Equal(t, hop.failure, RetryRead)
+
+	server, port, rwhois := getReferral("ReferralServer:  rwhois://rwhois.example.net:4321/\n")
+	assert.Equal(t, server, "rwhois.example.net")
+	assert.Equal(t, port, "4321")
+	assert.True(t, rwhois)
+
+	server, port, rwhois = getReferral("ReferralServer: rwhois://rwhois.example.net\n")
+	assert.Equal(t, server, "rwhois.example.net")
+	assert.Equal(t, port, defaultRWhoisPort)
+	assert.True(t, rwhois)
+
+	server, port, rwhois = getReferral("ReferralServer: whois://whois.ripe.net\n")
+	assert.Equal(t, server, "whois.ripe.net")
+	assert.Equal(t, port, defaultWhoisPort)
+	assert.False(t, rwhois)
+}
+
+func TestClient_RetryQuery(t *testing.T) {
+	InitWhois("config/test.json")
+
+	var count int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = bufio.NewReader(conn).ReadString('\n')
+			if atomic.AddInt32(&count, 1) < 3 {
+				_, _ = io.WriteString(conn, "% Query rate limit exceeded\n")
+			} else {
+				_, _ = io.WriteString(conn, "Domain Name: LIKEXIAN.COM\n")
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	query := func(c *Client, result *QueryResult) QueryHop {
+		return c.retryQuery(context.Background(), result, func() QueryHop {
+			return c.rawQuery(context.Background(), "likexian.com", "127.0.0.1", port)
+		})
+	}
+
+	result := &QueryResult{}
+	hop := query(NewClient().SetLimiter(nil), result)
+	assert.Nil(t, hop.Err)
+	assert.Equal(t, len(result.Hops), 1)
+	assert.Contains(t, hop.Response, "limit exceeded")
+
+	atomic.StoreInt32(&count, 0)
+	result = &QueryResult{}
+	c := NewClient().SetLimiter(nil).SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		RetryOn:        RetryLimitExceeded,
+	})
+	hop = query(c, result)
+	result.finish()
+	assert.Nil(t, hop.Err)
+	assert.Equal(t, hop.Attempt, 3)
+	assert.Equal(t, len(result.Hops), 3)
+	assert.True(t, result.Hops[0].Retried)
+	assert.Equal(t, result.String(), "Domain Name: LIKEXIAN.COM\n")
+
+	ln.Close()
+	result = &QueryResult{}
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, RetryOn: RetryTransient})
+	hop = query(c, result)
+	assert.NotNil(t, hop.Err)
+	assert.Equal(t, hop.failure, RetryConnect)
+	assert.Equal(t, len(result.Hops), 2)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.Equal(t, policy.backoff(1), 100*time.Millisecond)
+	assert.Equal(t, policy.backoff(2), 200*time.Millisecond)
+	assert.Equal(t, policy.backoff(3), 300*time.Millisecond)
+
+	policy = DefaultRetryPolicy()
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(1)
+		assert.True(t, delay >= 400*time.Millisecond && delay <= 600*time.Millisecond)
+	}
+
+	var config ServerConfig
+	err := json.Unmarshal([]byte(`{"servers": {"de": "whois.denic.de"},
+		"fallbacks": {"de": ["whois2.denic.de", "whois3.denic.de"]}}`), &config)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Fallbacks["de"], []string{"whois2.denic.de", "whois3.denic.de"})
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(RateLimit{Rate: 20, Burst: 2})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := limiter.Wait(context.Background(), "whois.example.com", nil)
+		assert.Nil(t, err)
+		release()
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	state, ok := limiter.State("whois.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, state.Queries, uint64(3))
+	assert.Equal(t, state.Limit, RateLimit{Rate: 20, Burst: 2})
+
+	release, err := limiter.Wait(context.Background(), "whois.denic.de", &RateLimit{MaxConcurrent: 1})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Wait(ctx, "whois.denic.de", &RateLimit{MaxConcurrent: 1})
+	assert.Equal(t, err, context.DeadlineExceeded)
+	state, _ = limiter.State("whois.denic.de")
+	assert.Equal(t, state.Active, 1)
+	assert.Equal(t, state.Waiting, 0)
+	release()
+	state, _ = limiter.State("whois.denic.de")
+	assert.Equal(t, state.Active, 0)
+
+	limiter.Observe(QueryHop{Server: "whois.denic.de", Response: "55000000002 Connection refused; access control limit exceeded"})
+	state, _ = limiter.State("whois.denic.de")
+	assert.Equal(t, state.LimitExceeded, uint64(1))
+	assert.Equal(t, state.Penalty, Duration(defaultLimitPenalty))
+	assert.False(t, state.PausedUntil.IsZero())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Wait(ctx, "whois.denic.de", &RateLimit{MaxConcurrent: 1})
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	limiter.Observe(QueryHop{Server: "whois.denic.de", Response: "limit exceeded"})
+	state, _ = limiter.State("whois.denic.de")
+	assert.Equal(t, state.Penalty, Duration(2*defaultLimitPenalty))
+	limiter.Observe(QueryHop{Server: "whois.denic.de", Response: "Domain: denic.de"})
+	limiter.Observe(QueryHop{Server: "whois.denic.de", Response: "Domain: denic.de"})
+	state, _ = limiter.State("whois.denic.de")
+	assert.Equal(t, state.Penalty, Duration(0))
+
+	states := limiter.States()
+	assert.Equal(t, len(states), 2)
+	assert.Equal(t, states[0].Server, "whois.denic.de")
+
+	// 有查询在使用时不应用其他客户端的配置
+	release, err = limiter.Wait(context.Background(), "whois.nic.uk", &RateLimit{MaxConcurrent: 1})
+	assert.Nil(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Wait(ctx, "whois.nic.uk", &RateLimit{MaxConcurrent: 2})
+	assert.Equal(t, err, context.DeadlineExceeded)
+	state, _ = limiter.State("whois.nic.uk")
+	assert.Equal(t, state.Limit, RateLimit{MaxConcurrent: 1})
+	release()
+	release, err = limiter.Wait(context.Background(), "whois.nic.uk", &RateLimit{MaxConcurrent: 2})
+	assert.Nil(t, err)
+	release()
+	state, _ = limiter.State("whois.nic.uk")
+	assert.Equal(t, state.Limit, RateLimit{MaxConcurrent: 2})
+
+	// 空闲的服务器状态被清除，暂停中的保留
+	limiter.mu.Lock()
+	limiter.lastSweep = time.Now().Add(-limiterIdleTimeout)
+	for _, s := range limiter.servers {
+		s.used = time.Now().Add(-limiterIdleTimeout)
+	}
+	limiter.mu.Unlock()
+	release, err = limiter.Wait(context.Background(), "whois.example.net", nil)
+	assert.Nil(t, err)
+	release()
+	_, ok = limiter.State("whois.example.com")
+	assert.False(t, ok)
+	_, ok = limiter.State("whois.denic.de")
+	assert.True(t, ok)
+	assert.Equal(t, len(limiter.States()), 2)
+
+	var config ServerConfig
+	err = json.Unmarshal([]byte(`{"options": {"whois.nic.uk": {"rate_limit": {"rate": 0.5, "burst": 5, "max_concurrent": 2}}}}`), &config)
+	assert.Nil(t, err)
+	assert.Equal(t, *config.Options["whois.nic.uk"].RateLimit, RateLimit{Rate: 0.5, Burst: 5, MaxConcurrent: 2})
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", 1, time.Hour)
+	cache.Set("b", 2, time.Hour)
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+
+	// b是最久未使用的，被淘汰
+	cache.Set("c", 3, time.Hour)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	v, ok := cache.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, v, 3)
+	assert.Equal(t, cache.Len(), 2)
+
+	cache.Set("d", 4, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = cache.Get("d")
+	assert.False(t, ok)
+
+	cache.Set("a", 5, 0)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	cache.Purge()
+	assert.Equal(t, cache.Len(), 0)
+}
+
+func TestClient_QueryCache(t *testing.T) {
+	var count int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			query, _ := bufio.NewReader(conn).ReadString('\n')
+			if strings.HasPrefix(query, "likexian.com") {
+				_, _ = io.WriteString(conn, "Domain Name: LIKEXIAN.COM\n")
+			} else {
+				_, _ = io.WriteString(conn, "No match for \"NOTFOUND.COM\".\n")
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	c := NewClient().SetLimiter(nil).SetDisableReferral(true).SetCache(NewMemoryCache(10))
+	c.serverMap = NewServerMap()
+	c.serverMap.config.Options["127.0.0.1"] = ServerOptions{Port: port}
+
+	r, err := c.Query("www.likexian.com", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Cache, CacheMiss)
+	r, err = c.Query("likexian.com", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Cache, CacheHit)
+	assert.Equal(t, r.String(), "Domain Name: LIKEXIAN.COM\n")
+	assert.Equal(t, r.Final.Server, "127.0.0.1")
+	assert.Equal(t, atomic.LoadInt32(&count), int32(1))
+
+	// 不同的referral设置使用不同的缓存
+	c.SetDisableReferral(false)
+	r, _ = c.Query("likexian.com", "127.0.0.1")
+	assert.Equal(t, r.Cache, CacheMiss)
+	assert.Equal(t, atomic.LoadInt32(&count), int32(2))
+	c.SetDisableReferral(true)
+
+	c.SetCacheTTL(CacheTTL{Positive: time.Hour})
+	for i := 0; i < 2; i++ {
+		r, err = c.Query("notfound.com", "127.0.0.1")
+		assert.Nil(t, err)
+		assert.Equal(t, r.Cache, CacheMiss)
+	}
+	assert.Equal(t, atomic.LoadInt32(&count), int32(4))
+
+	c.SetCacheTTL(DefaultCacheTTL())
+	_, _ = c.Query("notfound.com", "127.0.0.1")
+	r, _ = c.Query("notfound.com", "127.0.0.1")
+	assert.Equal(t, r.Cache, CacheHit)
+	assert.Equal(t, atomic.LoadInt32(&count), int32(5))
+
+	ln.Close()
+	_, err = c.Query("error.com", "127.0.0.1")
+	assert.NotNil(t, err)
+	r, err = c.Query("error.com", "127.0.0.1")
+	assert.NotNil(t, err)
+	assert.Equal(t, r.Cache, CacheHit)
+}
+
+func TestRDAPClient_QueryCache(t *testing.T) {
+	InitRDAP("")
+
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if strings.HasSuffix(r.URL.Path, "/notfound.cachetest") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{"objectClassName": "domain", "ldhName": "example.cachetest", "status": ["active"]}`)
+	}))
+	defer srv.Close()
+
+	err := defaultRdapMap().LoadBootstrap(RDAPBootstrap{
+		DNS: RDAPData{Services: [][][]string{{{"cachetest"}, {srv.URL + "/"}}}},
+	})
+	assert.Nil(t, err)
+
+	c := NewRDAPClient().SetCache(NewMemoryCache(10))
+	r, err := c.Query("example.cachetest")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Cache, CacheMiss)
+	assert.Equal(t, r.URL, srv.URL+"/domain/example.cachetest")
+
+	// 修改返回的数据不影响缓存
+	r.Data["ldhName"] = "changed"
+	r, err = c.Query("example.cachetest")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Cache, CacheHit)
+	assert.Equal(t, r.Data["ldhName"], "example.cachetest")
+	assert.Equal(t, atomic.LoadInt32(&count), int32(1))
+
+	_, err = c.Query("notfound.cachetest")
+	assert.True(t, errors.Is(err, ErrRDAPNotFound))
+	r, err = c.Query("notfound.cachetest")
+	assert.True(t, errors.Is(err, ErrRDAPNotFound))
+	assert.Equal(t, r.Cache, CacheHit)
+	assert.Equal(t, atomic.LoadInt32(&count), int32(2))
+}
+
+func TestClient_QueryCoalescing(t *testing.T) {
+	var count int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			go func() {
+				_, _ = bufio.NewReader(conn).ReadString('\n')
+				time.Sleep(100 * time.Millisecond)
+				_, _ = io.WriteString(conn, "Domain Name: LIKEXIAN.COM\n")
+				conn.Close()
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	// 只有使用相同服务器配置的客户端共享查询
+	sm := NewServerMap()
+	sm.config.Options["127.0.0.1"] = ServerOptions{Port: port}
+	newClient := func() *Client {
+		return NewClient(WithServerMap(sm)).SetLimiter(nil).SetDisableReferral(true)
+	}
+
+	results := make([]*QueryResult, 5)
+	done := make(chan int)
+	for i := range results {
+		go func(i int) {
+			results[i], _ = newClient().Query("likexian.com", "127.0.0.1")
+			done <- i
+		}(i)
+	}
+	for range results {
+		<-done
+	}
+
+	assert.Equal(t, atomic.LoadInt32(&count), int32(1))
+	for _, r := range results {
+		assert.True(t, r.Shared)
+		assert.Equal(t, r.String(), "Domain Name: LIKEXIAN.COM\n")
+	}
+	results[0].Hops[0].Response = "changed"
+	assert.Equal(t, results[1].Hops[0].Response, "Domain Name: LIKEXIAN.COM\n")
+
+	// 先到的调用方取消后，查询继续为其他调用方进行
+	atomic.StoreInt32(&count, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := newClient().QueryContext(ctx, "likexian.com", "127.0.0.1")
+		assert.True(t, errors.Is(err, context.Canceled))
+		done <- 0
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		results[0], _ = newClient().Query("likexian.com", "127.0.0.1")
+		done <- 1
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	<-done
+	assert.Equal(t, atomic.LoadInt32(&count), int32(1))
+	assert.Nil(t, results[0].Final.Err)
+	assert.True(t, results[0].Shared)
+
+	// 使用相同服务器配置但连接方式不同的客户端不共享查询
+	atomic.StoreInt32(&count, 0)
+	clients := []*Client{
+		newClient().SetTransport(dialerTransport{dialer: &net.Dialer{}}),
+		newClient().SetTransport(dialerTransport{dialer: &net.Dialer{}}),
+		newClient().SetTimeout(time.Minute),
+	}
+	for i, c := range clients {
+		go func(i int, c *Client) {
+			results[i], _ = c.Query("likexian.com", "127.0.0.1")
+			done <- i
+		}(i, c)
+	}
+	for range clients {
+		<-done
+	}
+	assert.Equal(t, atomic.LoadInt32(&count), int32(len(clients)))
+	for i := range clients {
+		assert.False(t, results[i].Shared)
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := &flightGroup{}
+	canceled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err, shared := g.do(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, err, context.Canceled)
+	assert.False(t, shared)
+
+	// 所有调用方离开后查询被取消
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("flight call is not canceled")
+	}
+
+	// 查询保留调用方context中的值
+	type ctxKey struct{}
+	v, err, shared := g.do(context.WithValue(context.Background(), ctxKey{}, 1), "key",
+		func(ctx context.Context) (interface{}, error) {
+			return ctx.Value(ctxKey{}), nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, v, 1)
+	assert.False(t, shared)
+}
+
+func TestClient_WhoisBatch(t *testing.T) {
+	var active, maxActive int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				n := atomic.AddInt32(&active, 1)
+				for {
+					m := atomic.LoadInt32(&maxActive)
+					if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+						break
+					}
+				}
+				query, _ := bufio.NewReader(conn).ReadString('\n')
+				if strings.HasPrefix(query, "slow") {
+					time.Sleep(time.Second)
+				} else {
+					time.Sleep(20 * time.Millisecond)
+				}
+				atomic.AddInt32(&active, -1)
+				_, _ = io.WriteString(conn, "Domain Name: "+strings.ToUpper(strings.TrimSpace(query))+"\n")
+				conn.Close()
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	c := NewClient().SetLimiter(nil).SetDisableReferral(true)
+	c.serverMap = NewServerMap()
+	c.serverMap.config.Servers["batchtest"] = "127.0.0.1"
+	c.serverMap.config.Options["127.0.0.1"] = ServerOptions{Port: port}
+
+	queries := []string{"a.batchtest", "b.batchtest", "c.batchtest", "d.batchtest", "e.batchtest", "f.batchtest"}
+	seen := map[int]bool{}
+	for r := range c.WhoisBatch(context.Background(), queries, BatchOptions{Workers: 2}) {
+		assert.Nil(t, r.Err)
+		assert.Equal(t, r.Query, queries[r.Index])
+		assert.Equal(t, r.Result.String(), "Domain Name: "+strings.ToUpper(queries[r.Index])+"\n")
+		seen[r.Index] = true
+	}
+	assert.Equal(t, len(seen), len(queries))
+	assert.True(t, atomic.LoadInt32(&maxActive) <= 2)
+
+	// 超时后未开始的查询返回ctx错误
+	queries = []string{"slow.batchtest", "slow2.batchtest", "g.batchtest"}
+	start := time.Now()
+	var failed int
+	for r := range c.WhoisBatch(context.Background(), queries, BatchOptions{Workers: 1, Timeout: 100 * time.Millisecond}) {
+		if r.Err != nil {
+			failed++
+			assert.True(t, errors.Is(r.Err, context.DeadlineExceeded))
+		}
+	}
+	assert.Equal(t, failed, 3)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestClient_FakeServer(t *testing.T) {
+	srv := whoistest.NewServer()
+	defer srv.Close()
+
+	srv.HandleText("whois.iana.org", "fake", "refer: whois.nic.fake\n")
+	srv.HandleText("whois.nic.fake", "example.fake",
+		"Domain Name: EXAMPLE.FAKE\nRegistrar WHOIS Server: whois.registrar.fake\n")
+	srv.HandleText("whois.nic.fake", "loop.fake", "Domain Name: LOOP.FAKE\nRegistrar WHOIS Server: whois.nic.fake\n")
+	srv.HandleText("whois.registrar.fake", "example.fake", "Registrant Name: Example\n")
+	srv.HandleText("whois.nic.fake", "reseller.fake",
+		"Domain Name: RESELLER.FAKE\nRegistrar WHOIS Server: whois.registrar.fake\n")
+	srv.HandleText("whois.registrar.fake", "reseller.fake",
+		"Registrant Name: Example\nRegistrar WHOIS Server: whois.reseller.fake\n")
+	srv.HandleText("whois.reseller.fake", "reseller.fake", "Reseller Name: Example\n")
+	srv.HandleText("whois2.nic.fb", "example.fb", "Domain Name: EXAMPLE.FB\n")
+	srv.Handle("whois.nic.slow", "", whoistest.Slow(time.Second, "Domain Name: EXAMPLE.SLOW\n"))
+	srv.Handle("whois.nic.hang", "", whoistest.Hang())
+	srv.Handle("whois.nic.truncated", "", whoistest.Truncated("Domain Name: EXAMPLE.TRUNCATED\n", 10))
+
+	newClient := func() *Client {
+		c := NewClient().SetTransport(srv).SetLimiter(nil)
+		c.serverMap = NewServerMap()
+		return c
+	}
+
+	// IANA发现后逐跳跟随referral
+	c := newClient()
+	r, err := c.Query("www.example.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Hops), 3)
+	assert.True(t, r.Hops[0].Discovery)
+	assert.Equal(t, r.Hops[1].Server, "whois.nic.fake")
+	assert.Equal(t, r.Final.Server, "whois.registrar.fake")
+	assert.Equal(t, r.String(), "Domain Name: EXAMPLE.FAKE\nRegistrar WHOIS Server: whois.registrar.fake\n"+
+		"Registrant Name: Example\n")
+	server, ok := c.serverMap.GetWhoisServer("fake")
+	assert.True(t, ok)
+	assert.Equal(t, server, "whois.nic.fake")
+
+	// 发现的服务器被缓存，referral循环被检测
+	r, err = c.Query("loop.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Hops), 1)
+
+	// 默认只跟随一次referral，需要更多时设置最大深度
+	r, err = c.Query("reseller.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Final.Server, "whois.registrar.fake")
+	r, err = c.SetMaxReferralDepth(2).Query("reseller.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Final.Server, "whois.reseller.fake")
+
+	_, err = newClient().Query("example.nosuch")
+	assert.True(t, errors.Is(err, ErrWhoisServerNotFound))
+
+	// 主服务器连接失败时使用备用服务器
+	c = newClient()
+	c.serverMap.config.Servers["fb"] = "whois.nic.fb"
+	c.serverMap.config.Fallbacks["fb"] = []string{"whois2.nic.fb"}
+	r, err = c.Query("example.fb")
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Hops), 2)
+	assert.Equal(t, r.Hops[0].failure, RetryConnect)
+	assert.Equal(t, r.Final.Server, "whois2.nic.fb")
+
+	c = newClient().SetTimeout(50 * time.Millisecond)
+	start := time.Now()
+	r, err = c.Query("example.slow", "whois.nic.slow")
+	assert.NotNil(t, err)
+	assert.Equal(t, r.Hops[0].failure, RetryTimeout)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = newClient().QueryContext(ctx, "example.hang", "whois.nic.hang")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// 读取中断时保留部分数据
+	r, err = newClient().Query("example.truncated", "whois.nic.truncated")
+	assert.Nil(t, err)
+	assert.True(t, r.Truncated)
+	assert.Equal(t, r.String(), "Domain Nam")
+	assert.Equal(t, r.Hops[0].failure, RetryRead)
+	assert.NotEqual(t, r.Hops[0].Error, "")
+
+	// 读取中断的hop按失败类型重试
+	r, _ = newClient().SetRetryPolicy(RetryPolicy{MaxAttempts: 2, RetryOn: RetryRead}).
+		Query("example.truncated", "whois.nic.truncated")
+	assert.Equal(t, len(r.Hops), 2)
+	assert.True(t, r.Hops[0].Retried)
+	assert.True(t, r.Truncated)
+
+	srv.HandleText("whois.nic.large", "", strings.Repeat("Domain Name: EXAMPLE.LARGE\n", 100))
+	r, err = newClient().SetMaxResponseSize(26).Query("example.large", "whois.nic.large")
+	assert.Nil(t, err)
+	assert.True(t, r.Truncated)
+	assert.Equal(t, r.String(), "Domain Name: EXAMPLE.LARGE")
+	assert.Equal(t, r.Final.failure, RetryClass(0))
+}
+
+func TestReadResponse(t *testing.T) {
+	data, truncated, err := readResponse(strings.NewReader("0123456789"), 10)
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, string(data), "0123456789")
+
+	data, truncated, err = readResponse(strings.NewReader("0123456789"), 4)
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, string(data), "0123")
+
+	data, truncated, err = readResponse(io.MultiReader(strings.NewReader("0123"), iotest.ErrReader(io.ErrUnexpectedEOF)), 0)
+	assert.Equal(t, err, io.ErrUnexpectedEOF)
+	assert.False(t, truncated)
+	assert.Equal(t, string(data), "0123")
+}
+
+// blockingDialer is a dialer without DialContext which dials after the delay
+type blockingDialer struct {
+	delay time.Duration
+	conns chan net.Conn
+}
+
+func (d *blockingDialer) Dial(network, addr string) (net.Conn, error) {
+	time.Sleep(d.delay)
+	client, server := net.Pipe()
+	d.conns <- server
+	return client, nil
+}
+
+func TestDialContext(t *testing.T) {
+	d := &blockingDialer{delay: 50 * time.Millisecond, conns: make(chan net.Conn, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	conn, err := dialContext(ctx, d, "tcp", "whois.example.com:43")
+	assert.True(t, conn == nil)
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	// 超时后完成的连接被关闭
+	server := <-d.conns
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = server.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	srv := whoistest.NewServer()
+	defer srv.Close()
+
+	srv.HandleText("whois.iana.org", "fake", "refer: whois.nic.fake\n")
+	srv.Handle("whois.nic.fake", "", func(conn net.Conn, query string) {
+		_, _ = io.WriteString(conn, "Domain Name: "+strings.ToUpper(query)+"\nRegistrar WHOIS Server: whois.registrar.fake\n")
+	})
+	srv.Handle("whois.registrar.fake", "", whoistest.Slow(40*time.Millisecond, "Registrant Name: Example\n"))
+
+	c := NewClient().SetTransport(srv).SetLimiter(nil)
+	c.serverMap = NewServerMap()
+
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			r, err := c.Query(fmt.Sprintf("example%d.fake", i))
+			if err == nil && r.Final.Server != "whois.registrar.fake" {
+				err = fmt.Errorf("unexpected final server %s", r.Final.Server)
+			}
+			done <- err
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, <-done)
+	}
+
+	// 超时限制整个查询，包括referral
+	srv.Handle("whois.registrar.fake", "", whoistest.Hang())
+	c.SetTimeout(100 * time.Millisecond)
+	start := time.Now()
+	r, err := c.Query("timeout.fake")
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, r.Final.Server, "whois.nic.fake")
+	assert.NotNil(t, r.Hops[len(r.Hops)-1].Err)
+}
+
+func TestLearnedServers(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), learnedServersFilename)
+	assert.Equal(t, LearnedServersFile(filepath.Join("config", "servers.json")), filepath.Join("config", learnedServersFilename))
+
+	sm := NewServerMap()
+	sm.config.Servers["com"] = "whois.verisign-grs.com"
+	assert.Nil(t, sm.EnableLearnedServers(filename, time.Hour))
+
+	// 配置中已有的后缀不会被学习
+	sm.SetWhoisServer("com", "whois.example.com")
+	sm.SetWhoisServer("fake", "whois.nic.fake")
+	server, ok := sm.GetWhoisServer("com")
+	assert.True(t, ok)
+	assert.Equal(t, server, "whois.verisign-grs.com")
+	suffix, server, ok := sm.LookupWhoisServer("example.fake")
+	assert.True(t, ok)
+	assert.Equal(t, suffix, "fake")
+	assert.Equal(t, server, "whois.nic.fake")
+
+	// 重启后从文件加载
+	sm = NewServerMap()
+	assert.Nil(t, sm.EnableLearnedServers(filename, time.Hour))
+	learned := sm.LearnedServers()
+	assert.Equal(t, len(learned), 1)
+	assert.Equal(t, learned["fake"].Server, "whois.nic.fake")
+	assert.Equal(t, learned["fake"].TTL, Duration(time.Hour))
+
+	err := sm.ImportLearnedServers(map[string]LearnedServer{
+		"old": {Server: "whois.nic.old", LearnedAt: time.Now().Add(-2 * time.Hour), TTL: Duration(time.Hour)},
+		"new": {Server: "whois.nic.new", LearnedAt: time.Now()},
+	})
+	assert.Nil(t, err)
+	_, ok = sm.GetWhoisServer("old")
+	assert.False(t, ok)
+	_, ok = sm.GetWhoisServer("new")
+	assert.True(t, ok)
+	assert.Equal(t, len(sm.LearnedServers()), 2)
+
+	n, err := sm.ExpireLearnedServers(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, n, 1)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	var file learnedServersFile
+	assert.Nil(t, json.Unmarshal(data, &file))
+	assert.Equal(t, len(file.Servers), 2)
+	assert.Equal(t, file.Servers["new"].Server, "whois.nic.new")
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	whoisFile := filepath.Join(dir, "servers.json")
+	rdapFile := filepath.Join(dir, "rdap.json")
+	writeConfig := func(filename, data string) {
+		assert.Nil(t, os.WriteFile(filename, []byte(data), 0644))
+	}
+	writeConfig(whoisFile, `{"servers": {"fake": "whois.nic.fake"}}`)
+	writeConfig(rdapFile, `{"dns": {"services": [[["fake"], ["https://rdap.nic.fake/"]]]}}`)
+
+	// 使用临时的默认配置，结束后恢复
+	sm, rm := NewServerMap(), NewRdapMap()
+	oldServerMap, oldRdapMap := serverMapInstance.Load(), rdapMapInstance.Load()
+	serverMapInstance.Store(sm)
+	rdapMapInstance.Store(rm)
+	defer func() {
+		serverMapInstance.Store(oldServerMap)
+		rdapMapInstance.Store(oldRdapMap)
+	}()
+	assert.Nil(t, sm.LoadFromFile(whoisFile))
+	assert.Nil(t, rm.LoadFromFile(rdapFile))
+	sm.SetWhoisServer("learned", "whois.nic.learned")
+
+	writeConfig(whoisFile, `{"servers": {"fake": "whois2.nic.fake"}}`)
+	writeConfig(rdapFile, `{"dns": {"services": [[["fake"], ["https://rdap2.nic.fake/"]]]}}`)
+	assert.Nil(t, Reload())
+	server, _ := sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois2.nic.fake")
+	_, url, _ := rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+	// 学习到的服务器在重新加载后保留
+	server, _ = sm.GetWhoisServer("learned")
+	assert.Equal(t, server, "whois.nic.learned")
+
+	// 任一配置无效时两个配置都保留
+	writeConfig(whoisFile, `{"servers": {"fake": "whois3.nic.fake"}}`)
+	writeConfig(rdapFile, `{"ipv4": {"services": [[["1.2.3.4/99"], ["https://rdap.nic.fake/"]]]}}`)
+	assert.NotNil(t, Reload())
+	server, _ = sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois2.nic.fake")
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+
+	writeConfig(whoisFile, `{"servers": {"fake": ""}}`)
+	assert.NotNil(t, sm.Reload())
+	writeConfig(whoisFile, `{"servers": `)
+	assert.NotNil(t, sm.Reload())
+	server, _ = sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois2.nic.fake")
+
+	// 在线获取的RDAP配置获取失败时不影响whois配置的重新加载
+	writeConfig(rdapFile, `{"dns": {"services": [[["fake"], ["https://rdap2.nic.fake/"]]]}}`)
+	rdapConfig, err := loadRdapConfig(rdapFile)
+	assert.Nil(t, err)
+	rm.setConfig(rdapConfig, "http://127.0.0.1:0/rdap/")
+	writeConfig(whoisFile, `{"servers": {"fake": "whois3.nic.fake"}}`)
+	assert.Nil(t, Reload())
+	server, _ = sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois3.nic.fake")
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+
+	// 启用刷新器时交给刷新器在后台更新
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		services := `[]`
+		if r.URL.Path == "/dns.json" {
+			services = `[[["fake"], ["https://rdap3.nic.fake/"]]]`
+		}
+		_, _ = io.WriteString(w, `{"publication": "2024-01-01T00:00:00Z", "services": `+services+`}`)
+	}))
+	defer mirror.Close()
+	defaultRefresherMu.Lock()
+	defaultRefresher = NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL})
+	defaultRefresherMu.Unlock()
+	assert.Nil(t, Reload())
+	for i := 0; i < 100 && defaultBootstrapRefresher().Status().LastRefresh.IsZero(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap3.nic.fake/domain/example.fake")
+	defaultRefresherMu.Lock()
+	defaultRefresher = nil
+	defaultRefresherMu.Unlock()
+	assert.Nil(t, rm.LoadFromFile(rdapFile))
+
+	// 文件修改后自动重新加载
+	writeConfig(rdapFile, `{"dns": {"services": [[["fake"], ["https://rdap2.nic.fake/"]]]}}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	reloaded := make(chan error, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchConfig(ctx, 10*time.Millisecond, func(err error) {
+			reloaded <- err
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	time.Sleep(50 * time.Millisecond)
+	writeConfig(whoisFile, `{"servers": {"fake": "whois4.nic.fake", "extra": "whois.nic.extra"}}`)
+	select {
+	case err := <-reloaded:
+		assert.Nil(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("config is not reloaded")
+	}
+	server, _ = sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois4.nic.fake")
+}
+
+func TestClientOptions(t *testing.T) {
+	// 未初始化时使用嵌入的默认配置
+	assert.NotNil(t, DefaultClient.servers())
+	assert.NotNil(t, DefaultRDAPClient.servers())
+
+	srv := whoistest.NewServer()
+	defer srv.Close()
+	srv.Handle("whois.nic.one", "", whoistest.Slow(50*time.Millisecond, "Domain Name: EXAMPLE.FAKE\nServer: one\n"))
+	srv.Handle("whois.nic.two", "", whoistest.Slow(50*time.Millisecond, "Domain Name: EXAMPLE.FAKE\nServer: two\n"))
+
+	// 不同服务器配置的客户端互不影响，相同的查询也不共享结果
+	newClient := func(server string) *Client {
+		sm := NewServerMap()
+		sm.config.Servers["fake"] = server
+		return NewClient(WithServerMap(sm), WithTransport(srv)).SetLimiter(nil)
+	}
+	one, two := newClient("whois.nic.one"), newClient("whois.nic.two")
+	var wg sync.WaitGroup
+	results := make([]*QueryResult, 2)
+	for i, c := range []*Client{one, two} {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			results[i], _ = c.Query("example.fake")
+		}(i, c)
+	}
+	wg.Wait()
+	assert.Equal(t, results[0].Final.Server, "whois.nic.one")
+	assert.False(t, results[0].Shared)
+	assert.Equal(t, results[1].Final.Server, "whois.nic.two")
+	assert.False(t, results[1].Shared)
+
+	// 共享缓存时不同服务器配置的结果互不影响
+	cache := NewMemoryCache(10)
+	one.SetCache(cache)
+	two.SetCache(cache)
+	r1, err := one.Query("example.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r1.Cache, CacheMiss)
+	r2, err := two.Query("example.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r2.Cache, CacheMiss)
+	assert.Equal(t, r2.Final.Server, "whois.nic.two")
+	r1, _ = one.Query("example.fake")
+	assert.Equal(t, r1.Cache, CacheHit)
+	assert.Equal(t, r1.Final.Server, "whois.nic.one")
+
+	var count int32
+	rdapSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		_, _ = io.WriteString(w, `{"objectClassName": "domain", "ldhName": "example.fake"}`)
+	}))
+	defer rdapSrv.Close()
+
+	rm := NewRdapMap()
+	err = rm.LoadBootstrap(RDAPBootstrap{
+		DNS: RDAPData{Services: [][][]string{{{"fake"}, {rdapSrv.URL + "/"}}}},
+	})
+	assert.Nil(t, err)
+	c := NewRDAPClient(WithRDAPMap(rm), WithHTTPClient(rdapSrv.Client())).SetCache(cache)
+	r, err := c.Query("example.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r.URL, rdapSrv.URL+"/domain/example.fake")
+	assert.Equal(t, atomic.LoadInt32(&count), int32(1))
+
+	// 共享缓存的另一个RDAP配置重新查询
+	rm2 := NewRdapMap()
+	err = rm2.LoadBootstrap(RDAPBootstrap{
+		DNS: RDAPData{Services: [][][]string{{{"fake"}, {rdapSrv.URL + "/v2/"}}}},
+	})
+	assert.Nil(t, err)
+	r, err = NewRDAPClient(WithRDAPMap(rm2), WithHTTPClient(rdapSrv.Client())).SetCache(cache).Query("example.fake")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Cache, CacheMiss)
+	assert.Equal(t, r.URL, rdapSrv.URL+"/v2/domain/example.fake")
+	assert.Equal(t, atomic.LoadInt32(&count), int32(2))
+
+	// 默认配置中没有该后缀
+	_, err = NewRDAPClient().Query("example.fake")
+	assert.NotNil(t, err)
+}
+
+// testLogger records the messages
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) add(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+" "+msg+" "+strings.TrimSpace(fmt.Sprintln(args...)))
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.add("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.add("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.add("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.add("ERROR", msg, args) }
+
+func TestLogger(t *testing.T) {
+	_, ok := getLogger().(nopLogger)
+	assert.True(t, ok)
+
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogLevelInfo)
+	logger.Debug("hidden", "query", "example.com")
+	logger.Info("whois lookup", "query", "example.com", "duration", time.Second, "error", "read: reset by peer", "odd")
+	assert.Equal(t, buf.String(), "INFO whois lookup query=example.com duration=1s error=\"read: reset by peer\" !BADKEY=odd\n")
+
+	level, err := ParseLogLevel("DEBUG")
+	assert.Nil(t, err)
+	assert.Equal(t, level, LogLevelDebug)
+	_, err = ParseLogLevel("verbose")
+	assert.NotNil(t, err)
+
+	srv := whoistest.NewServer()
+	defer srv.Close()
+	srv.HandleText("whois.nic.fake", "example.fake", "Domain Name: EXAMPLE.FAKE\n")
+
+	sm := NewServerMap()
+	sm.config.Servers["fake"] = "whois.nic.fake"
+	tl := &testLogger{}
+	c := NewClient(WithServerMap(sm), WithTransport(srv)).SetLimiter(nil).SetLogger(tl)
+	_, err = c.Query("example.fake")
+	assert.Nil(t, err)
+	_, err = c.Query("example.nosuch", "whois.nic.nosuch")
+	assert.NotNil(t, err)
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	assert.Equal(t, len(tl.messages), 4)
+	assert.True(t, strings.HasPrefix(tl.messages[0], "DEBUG whois hop query example.fake server whois.nic.fake"))
+	assert.True(t, strings.HasPrefix(tl.messages[1], "DEBUG whois lookup query example.fake hops 1"))
+	assert.True(t, strings.HasPrefix(tl.messages[3], "WARN whois lookup failed query example.nosuch"))
+}
+
+func TestGenerateServerConfig(t *testing.T) {
+	srv := whoistest.NewServer()
+	defer srv.Close()
+	srv.HandleText("whois.iana.org", "com", "domain:       COM\n\nwhois:        whois.verisign-grs.com\n")
+	srv.HandleText("whois.iana.org", "new", "domain:       NEW\n\nwhois:        WHOIS.NIC.NEW\n")
+	srv.HandleText("whois.iana.org", "gone", "domain:       GONE\n\nstatus:       ACTIVE\n")
+	srv.HandleText("whois.iana.org", "moved", "domain:       MOVED\n\nwhois:        whois2.nic.moved\n")
+	srv.Handle("whois.iana.org", "broken", whoistest.Hang())
+
+	tldList := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "# Version 2024010100, Last Updated Mon Jan  1 07:07:01 2024 UTC\n"+
+			"COM\nNEW\nGONE\nMOVED\nBROKEN\n")
+	}))
+	defer tldList.Close()
+
+	base := &ServerConfig{
+		Servers: map[string]string{
+			"com":    "whois.verisign-grs.com",
+			"gone":   "whois.nic.gone",
+			"moved":  "whois.nic.moved",
+			"broken": "whois.nic.broken",
+			"co.uk":  "whois.nic.uk",
+			"old":    "whois.nic.old",
+		},
+		Rewrite: map[string]string{"whois.example": "whois.example.com"},
+	}
+
+	c := NewClient(WithServerMap(NewServerMap()), WithTransport(srv)).SetLimiter(nil).SetTimeout(200 * time.Millisecond)
+	config, diff, err := c.GenerateServerConfig(context.Background(), base, GenerateOptions{
+		TLDListURL: tldList.URL,
+		HTTPClient: tldList.Client(),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, diff.Added, map[string]string{"new": "whois.nic.new"})
+	// 根区列表中已不存在的TLD被删除，IANA响应中没有whois服务器的TLD保留
+	assert.Equal(t, diff.Removed, map[string]string{"old": "whois.nic.old"})
+	assert.Equal(t, diff.Missing, map[string]string{"gone": "whois.nic.gone"})
+	assert.Equal(t, diff.Changed, map[string]ServerChange{"moved": {Old: "whois.nic.moved", New: "whois2.nic.moved"}})
+	assert.Equal(t, len(diff.Failed), 1)
+	assert.NotEqual(t, diff.Failed["broken"], "")
+	assert.Equal(t, diff.String(), "added: 1, removed: 1, changed: 1, failed: 1, missing: 1\n"+
+		"+ new whois.nic.new\n- old whois.nic.old\n~ moved whois.nic.moved -> whois2.nic.moved\n"+
+		"! broken "+diff.Failed["broken"]+"\n? gone whois.nic.gone\n")
+
+	// 失败的TLD和非TLD的后缀保留原配置，基础配置不被修改
+	assert.Equal(t, config.Servers, map[string]string{
+		"com":    "whois.verisign-grs.com",
+		"new":    "whois.nic.new",
+		"gone":   "whois.nic.gone",
+		"moved":  "whois2.nic.moved",
+		"broken": "whois.nic.broken",
+		"co.uk":  "whois.nic.uk",
+	})
+	assert.Equal(t, config.Rewrite, base.Rewrite)
+	assert.Equal(t, base.Servers["gone"], "whois.nic.gone")
+
+	filename := filepath.Join(t.TempDir(), "servers.json")
+	assert.Nil(t, WriteServerConfig(filename, config))
+	loaded, err := LoadServerConfig(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.Servers, config.Servers)
+
+	// 新文件权限为0644，重新生成时保留原文件的权限
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0644))
+	assert.Nil(t, os.Chmod(filename, 0640))
+	assert.Nil(t, WriteServerConfig(filename, config))
+	info, err = os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0640))
+
+	// 指定TLD时不请求TLD列表
+	_, diff, err = c.GenerateServerConfig(context.Background(), nil, GenerateOptions{
+		TLDs:       []string{"COM."},
+		TLDListURL: "http://127.0.0.1:1/",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, diff.Added, map[string]string{"com": "whois.verisign-grs.com"})
+}
+
+func TestBootstrapRefresher(t *testing.T) {
+	var mu sync.Mutex
+	publication, server, fail := "2024-01-01T00:00:00Z", "https://rdap.nic.fake/", false
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		services := `[]`
+		if r.URL.Path == "/rdap/dns.json" {
+			services = `[[["fake"], ["` + server + `"]]]`
+		}
+		_, _ = io.WriteString(w, `{"publication": "`+publication+`", "services": `+services+`}`)
+	}))
+	defer mirror.Close()
+	update := func(p, s string, f bool) {
+		mu.Lock()
+		publication, server, fail = p, s, f
+		mu.Unlock()
+	}
+
+	cacheFile := filepath.Join(t.TempDir(), "rdap.bootstrap.json")
+	rm := NewRdapMap()
+	r := NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL + "/rdap", CacheFile: cacheFile})
+	assert.Nil(t, r.Load(context.Background()))
+	status := r.Status()
+	assert.Equal(t, status.Source, BootstrapSourceOnline)
+	assert.Equal(t, status.Publications["dns"], "2024-01-01T00:00:00Z")
+	_, url, _ := rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap.nic.fake/domain/example.fake")
+
+	// publication未变化时不替换
+	update("2024-01-01T00:00:00Z", "https://rdap2.nic.fake/", false)
+	changed, err := r.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap.nic.fake/domain/example.fake")
+
+	update("2024-02-01T00:00:00Z", "https://rdap2.nic.fake/", false)
+	changed, err = r.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+
+	// 获取失败时保留当前的bootstrap
+	update("2024-03-01T00:00:00Z", "https://rdap3.nic.fake/", true)
+	_, err = r.Refresh(context.Background())
+	assert.NotNil(t, err)
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+	status = r.Status()
+	assert.NotEqual(t, status.LastError, "")
+	assert.Equal(t, status.Publications["dns"], "2024-02-01T00:00:00Z")
+
+	// 获取失败时使用最后一次有效的bootstrap
+	rm = NewRdapMap()
+	r = NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL + "/rdap", CacheFile: cacheFile})
+	assert.NotNil(t, r.Load(context.Background()))
+	assert.Equal(t, r.Status().Source, BootstrapSourceCache)
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap2.nic.fake/domain/example.fake")
+
+	// 没有缓存文件时使用内置的配置
+	rm = NewRdapMap()
+	r = NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL + "/rdap"})
+	assert.NotNil(t, r.Load(context.Background()))
+	assert.Equal(t, r.Status().Source, BootstrapSourceEmbedded)
+	_, _, ok := rm.GetRdapServer("example.com")
+	assert.True(t, ok)
+
+	// 恢复后重新使用在线的bootstrap
+	update("2024-03-01T00:00:00Z", "https://rdap3.nic.fake/", false)
+	changed, err = r.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, r.Status().Source, BootstrapSourceOnline)
+	assert.Equal(t, rm.Source(), mirror.URL+"/rdap")
+
+	// 启动时获取失败，按重试间隔尽快恢复在线的bootstrap
+	update("2024-04-01T00:00:00Z", "https://rdap4.nic.fake/", true)
+	rm = NewRdapMap()
+	r = NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL + "/rdap", Interval: time.Hour,
+		RetryInterval: 10 * time.Millisecond})
+	assert.NotNil(t, r.Load(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	time.Sleep(30 * time.Millisecond)
+	update("2024-04-01T00:00:00Z", "https://rdap4.nic.fake/", false)
+	for i := 0; i < 200 && r.Status().Source != BootstrapSourceOnline; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, r.Status().Source, BootstrapSourceOnline)
+	_, url, _ = rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap4.nic.fake/domain/example.fake")
+	// 恢复后按正常间隔刷新
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, time.Until(r.Status().NextRefresh) > 30*time.Minute)
+
+	// 超出大小限制的文件返回明确的错误
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte(" "), maxRDAPBootstrapSize+1))
+	}))
+	defer large.Close()
+	_, err = fetchRDAPBootstrapFile(context.Background(), large.Client(), large.URL+"/dns.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "too large")
+}
+
+func TestBootstrapRefresher_NoObjectTags(t *testing.T) {
+	var mu sync.Mutex
+	publication, tags := "2024-01-01T00:00:00Z", true
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		services := `[]`
+		switch r.URL.Path {
+		case "/rdap/dns.json":
+			services = `[[["fake"], ["https://rdap.nic.fake/"]]]`
+		case "/rdap/object-tags.json":
+			if !tags {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			services = `[[["contact@nic.fake"], ["FAKE"], ["https://rdap.nic.fake/"]]]`
+		}
+		_, _ = io.WriteString(w, `{"publication": "`+publication+`", "services": `+services+`}`)
+	}))
+	defer mirror.Close()
+	update := func(p string, t bool) {
+		mu.Lock()
+		publication, tags = p, t
+		mu.Unlock()
+	}
+
+	// 只有dns、ipv4、ipv6、asn文件的镜像使用内置的标签
+	update("2024-01-01T00:00:00Z", false)
+	rm := NewRdapMap()
+	r := NewBootstrapRefresher(rm, BootstrapRefreshOptions{BaseURL: mirror.URL + "/rdap"})
+	assert.Nil(t, r.Load(context.Background()))
+	assert.Equal(t, r.Status().Source, BootstrapSourceOnline)
+	_, url, _ := rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap.nic.fake/domain/example.fake")
+	_, ok := rm.GetEntityServer("ABUSE7610-ARIN")
+	assert.True(t, ok)
+
+	// object-tags文件消失后保留之前的标签，其他文件照常更新
+	update("2024-02-01T00:00:00Z", true)
+	changed, err := r.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	url, ok = rm.GetEntityServer("X-FAKE")
+	assert.True(t, ok)
+	assert.Equal(t, url, "https://rdap.nic.fake/entity/X-FAKE")
+
+	update("2024-03-01T00:00:00Z", false)
+	changed, err = r.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, r.Status().Publications["dns"], "2024-03-01T00:00:00Z")
+	assert.Equal(t, r.Status().Publications["object-tags"], "2024-02-01T00:00:00Z")
+	_, ok = rm.GetEntityServer("X-FAKE")
+	assert.True(t, ok)
+}
+
+func TestRDAPClient_Entity(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/registry/entity/ABUSE7610-ARIN" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{
+			"objectClassName": "entity",
+			"handle": "ABUSE7610-ARIN",
+			"roles": ["abuse"],
+			"status": ["validated"],
+			"port43": "whois.arin.net",
+			"vcardArray": ["vcard", [
+				["version", {}, "text", "4.0"],
+				["fn", {}, "text", "Abuse Contact"],
+				["org", {}, "text", "Example Org"],
+				["kind", {}, "text", "group"],
+				["adr", {"label": "100 Main St\nAnytown\nUS"}, "text", ["", "", "", "", "", "", ""]],
+				["email", {}, "text", "abuse@example.com"],
+				["tel", {"type": ["work", "voice"]}, "uri", "tel:+1-555-0100"]
+			]],
+			"events": [
+				{"eventAction": "registration", "eventDate": "2020-01-01T00:00:00Z"},
+				{"eventAction": "last changed", "eventDate": "2024-01-01T00:00:00Z"}
+			]
+		}`)
+	}))
+	defer srv.Close()
+
+	rm := NewRdapMap()
+	err := rm.LoadBootstrap(RDAPBootstrap{
+		ObjectTags: RDAPData{Services: [][][]string{
+			{{"contact@example.com"}, {"ARIN"}, {"http://rdap.arin.fake/registry/", srv.URL + "/registry/"}},
+		}},
+	})
+	assert.Nil(t, err)
+	url, ok := rm.GetEntityServer("abuse7610-arin")
+	assert.True(t, ok)
+	assert.Equal(t, url, srv.URL+"/registry/entity/abuse7610-arin")
+	_, ok = rm.GetEntityServer("ARIN")
+	assert.False(t, ok)
+
+	c := NewRDAPClient(WithRDAPMap(rm), WithHTTPClient(srv.Client()))
+	entity, err := c.Entity("ABUSE7610-ARIN")
+	assert.Nil(t, err)
+	assert.Equal(t, entity.Handle, "ABUSE7610-ARIN")
+	assert.Equal(t, entity.Name, "Abuse Contact")
+	assert.Equal(t, entity.Organization, "Example Org")
+	assert.Equal(t, entity.Kind, "group")
+	assert.Equal(t, entity.Address, "100 Main St, Anytown, US")
+	assert.Equal(t, entity.Emails, []string{"abuse@example.com"})
+	assert.Equal(t, entity.Phones, []string{"+1-555-0100"})
+	assert.Equal(t, entity.Roles, []string{"abuse"})
+	assert.Equal(t, entity.Port43, "whois.arin.net")
+	assert.Equal(t, entity.CreatedDate, "2020-01-01T00:00:00Z")
+	assert.Equal(t, entity.UpdatedDate, "2024-01-01T00:00:00Z")
+
+	_, err = c.Entity("MNT-XYZ-RIPE")
+	assert.True(t, errors.Is(err, ErrRDAPServerNotFound))
+	_, err = c.Entity("OTHER-ARIN")
+	assert.True(t, errors.Is(err, ErrRDAPNotFound))
+
+	// 内置配置包含RIR的对象标签
+	_, ok = NewRdapMap().GetEntityServer("MNT-XYZ-RIPE")
+	assert.False(t, ok)
+	rm = NewRdapMap()
+	assert.Nil(t, rm.LoadFromFile(""))
+	url, ok = rm.GetEntityServer("MNT-XYZ-RIPE")
+	assert.True(t, ok)
+	assert.Equal(t, url, "https://rdap.db.ripe.net/entity/MNT-XYZ-RIPE")
+}
+
+func TestRDAPClient_NameserverRegistrar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nameserver/ns1.example.fake":
+			_, _ = io.WriteString(w, `{
+				"objectClassName": "nameserver",
+				"handle": "NS1-FAKE",
+				"ldhName": "ns1.example.fake",
+				"status": ["active"],
+				"ipAddresses": {"v4": ["192.0.2.1"], "v6": ["2001:db8::1"]},
+				"events": [{"eventAction": "registration", "eventDate": "2020-01-01T00:00:00Z"}]
+			}`)
+		case "/entity/292":
+			_, _ = io.WriteString(w, `{
+				"objectClassName": "entity",
+				"handle": "292",
+				"roles": ["registrar"],
+				"publicIds": [{"type": "IANA Registrar ID", "identifier": "292"}],
+				"vcardArray": ["vcard", [
+					["version", {}, "text", "4.0"],
+					["fn", {}, "text", "Fake Registrar, Inc."],
+					["url", {}, "uri", "https://registrar.fake"]
+				]],
+				"entities": [{
+					"objectClassName": "entity",
+					"roles": ["abuse"],
+					"vcardArray": ["vcard", [
+						["version", {}, "text", "4.0"],
+						["email", {}, "text", "abuse@registrar.fake"],
+						["tel", {}, "uri", "tel:+1.5550100"]
+					]]
+				}],
+				"events": [{"eventAction": "last changed", "eventActor": "292", "eventDate": "2024-01-01T00:00:00Z"}]
+			}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	rm := NewRdapMap()
+	err := rm.LoadBootstrap(RDAPBootstrap{
+		DNS: RDAPData{Services: [][][]string{{{"fake", "com"}, {srv.URL + "/"}}}},
+	})
+	assert.Nil(t, err)
+	url, ok := rm.GetNameserverServer("NS1.Example.Fake.")
+	assert.True(t, ok)
+	assert.Equal(t, url, srv.URL+"/nameserver/ns1.example.fake")
+	_, ok = rm.GetNameserverServer("ns1.example.none")
+	assert.False(t, ok)
+	url, ok = rm.GetRegistrarServer("292", "")
+	assert.True(t, ok)
+	assert.Equal(t, url, srv.URL+"/entity/292")
+	_, ok = rm.GetRegistrarServer("abc", "fake")
+	assert.False(t, ok)
+
+	c := NewRDAPClient(WithRDAPMap(rm), WithHTTPClient(srv.Client()))
+	nameserver, err := c.Nameserver("ns1.example.fake.")
+	assert.Nil(t, err)
+	assert.Equal(t, nameserver.Name, "ns1.example.fake")
+	assert.Equal(t, nameserver.IPv4, []string{"192.0.2.1"})
+	assert.Equal(t, nameserver.IPv6, []string{"2001:db8::1"})
+	assert.Equal(t, nameserver.Status, []string{"active"})
+	assert.Equal(t, nameserver.Events, []parsers.EventInfo{{Action: "registration", Date: "2020-01-01T00:00:00Z"}})
+
+	registrar, err := c.Registrar("292", "fake")
+	assert.Nil(t, err)
+	assert.Equal(t, registrar.IANAID, "292")
+	assert.Equal(t, registrar.Name, "Fake Registrar, Inc.")
+	assert.Equal(t, registrar.URL, "https://registrar.fake")
+	assert.Equal(t, registrar.AbuseEmail, "abuse@registrar.fake")
+	assert.Equal(t, registrar.AbusePhone, "+1.5550100")
+	assert.Equal(t, registrar.Events, []parsers.EventInfo{{Action: "last changed", Actor: "292", Date: "2024-01-01T00:00:00Z"}})
+
+	_, err = c.Nameserver("ns1.example.none")
+	assert.True(t, errors.Is(err, ErrRDAPServerNotFound))
+	_, err = c.Query("example.none")
+	assert.True(t, errors.Is(err, ErrRDAPServerNotFound))
+	_, err = c.Registrar("1", "")
+	assert.True(t, errors.Is(err, ErrRDAPNotFound))
+	_, err = c.Registrar("292", "none")
+	assert.True(t, errors.Is(err, ErrRDAPServerNotFound))
+}
+
+func TestInitAfterLazyLoad(t *testing.T) {
+	dir := t.TempDir()
+	whoisFile := filepath.Join(dir, "servers.json")
+	rdapFile := filepath.Join(dir, "rdap.json")
+	assert.Nil(t, os.WriteFile(whoisFile, []byte(`{"servers": {"fake": "whois.nic.fake"}}`), 0644))
+	assert.Nil(t, os.WriteFile(rdapFile, []byte(`{"dns": {"services": [[["fake"], ["https://rdap.nic.fake/"]]]}}`), 0644))
+
+	// 使用未初始化的默认配置，结束后恢复
+	serverMapMu.Lock()
+	rdapMapMu.Lock()
+	oldServerMap, oldServerMapInited := serverMapInstance.Swap(nil), serverMapInited
+	oldRdapMap, oldRdapMapInited := rdapMapInstance.Swap(nil), rdapMapInited
+	serverMapInited, rdapMapInited = false, false
+	rdapMapMu.Unlock()
+	serverMapMu.Unlock()
+	defer func() {
+		serverMapMu.Lock()
+		rdapMapMu.Lock()
+		serverMapInstance.Store(oldServerMap)
+		rdapMapInstance.Store(oldRdapMap)
+		serverMapInited, rdapMapInited = oldServerMapInited, oldRdapMapInited
+		rdapMapMu.Unlock()
+		serverMapMu.Unlock()
+	}()
+
+	// 查询先懒加载内置配置
+	sm, rm := defaultServerMap(), defaultRdapMap()
+	_, ok := sm.GetWhoisServer("fake")
+	assert.False(t, ok)
+	sm.SetWhoisServer("learned", "whois.nic.learned")
+
+	// 显式初始化替换懒加载的内置配置
+	InitWhois(whoisFile)
+	InitRDAP(rdapFile)
+	assert.Equal(t, defaultServerMap(), sm)
+	server, _ := sm.GetWhoisServer("fake")
+	assert.Equal(t, server, "whois.nic.fake")
+	server, _ = sm.GetWhoisServer("learned")
+	assert.Equal(t, server, "whois.nic.learned")
+	assert.Equal(t, sm.Filename(), whoisFile)
+	assert.Equal(t, defaultRdapMap(), rm)
+	_, url, _ := rm.GetRdapServer("example.fake")
+	assert.Equal(t, url, "https://rdap.nic.fake/domain/example.fake")
+
+	// 只有第一次显式初始化生效
+	InitWhois("")
+	InitRDAP("")
+	assert.Equal(t, sm.Filename(), whoisFile)
+	assert.Equal(t, rm.Source(), rdapFile)
+}
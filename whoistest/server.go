@@ -0,0 +1,311 @@
+// Package whoistest provides an in-process fake whois server for tests
+package whoistest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultPort is the port of the hosts registered without a port
+const defaultPort = "43"
+
+// Handler responds a whois query on the connection, the connection is closed after it returns
+type Handler func(conn net.Conn, query string)
+
+// Server is a fake whois server, every registered host has its own local listener,
+// and the dial to the host address is redirected to the listener by DialContext
+type Server struct {
+	mu      sync.Mutex
+	hosts   map[string]*host
+	conns   map[net.Conn]bool
+	queries []Query
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Query is a query received by the server
+type Query struct {
+	// Addr is the host address dialed by the client, such as whois.iana.org:43
+	Addr string
+	// Query is the query line without the line ending
+	Query string
+}
+
+// host is a registered host with its listener and handlers
+type host struct {
+	listener net.Listener
+	handlers map[string]Handler
+	fallback Handler
+	connect  Handler
+}
+
+// NewServer returns a new fake whois server without any host
+func NewServer() *Server {
+	return &Server{
+		hosts:  make(map[string]*host),
+		conns:  make(map[net.Conn]bool),
+		closed: make(chan struct{}),
+	}
+}
+
+// Handle registers the handler of the query on the host, the host is such as whois.iana.org or localhost:4321,
+// the query is matched case-insensitively, an empty query matches all the queries without a handler.
+// the unmatched queries are responded with a not found message.
+func (s *Server) Handle(addr, query string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.host(addr)
+	if err != nil {
+		panic(err)
+	}
+
+	if query == "" {
+		h.fallback = handler
+	} else {
+		h.handlers[strings.ToLower(query)] = handler
+	}
+}
+
+// HandleConnect registers the handler called with an empty query as soon as a connection to the host is accepted,
+// before any query is read, such as the %rwhois banner of a rwhois server.
+// the connections of the host are kept open for more queries until the client closes them,
+// every query line is responded by its handler as Handle registers.
+func (s *Server) HandleConnect(addr string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.host(addr)
+	if err != nil {
+		panic(err)
+	}
+
+	h.connect = handler
+}
+
+// HandleText registers the text response of the query on the host
+func (s *Server) HandleText(addr, query, text string) {
+	s.Handle(addr, query, Text(text))
+}
+
+// Addr returns the listener address of the host, empty if the host is not registered
+func (s *Server) Addr(addr string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[hostKey(addr)]
+	if !ok {
+		return ""
+	}
+
+	return h.listener.Addr().String()
+}
+
+// DialContext connects to the listener of the host address,
+// it implements the Transport of the whois client
+func (s *Server) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	s.mu.Lock()
+	h, ok := s.hosts[hostKey(addr)]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("whoistest: %s: %w", addr, syscall.ECONNREFUSED)}
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", h.listener.Addr().String())
+}
+
+// Queries returns the queries received in order
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Query(nil), s.queries...)
+}
+
+// Close closes all the listeners and connections, and waits for the handlers to return
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	close(s.closed)
+	for _, h := range s.hosts {
+		_ = h.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// host returns the registered host, starts the listener if not registered, s.mu must be held
+func (s *Server) host(addr string) (*host, error) {
+	key := hostKey(addr)
+	if h, ok := s.hosts[key]; ok {
+		return h, nil
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &host{
+		listener: ln,
+		handlers: make(map[string]Handler),
+	}
+	s.hosts[key] = h
+
+	s.wg.Add(1)
+	go s.serve(key, h)
+
+	return h, nil
+}
+
+// serve accepts the connections of the host
+func (s *Server) serve(addr string, h *host) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(addr, h, conn)
+	}
+}
+
+// serveConn reads the query line and calls the handler,
+// the connect handler is called first and the queries are served until the client closes if it is registered
+func (s *Server) serveConn(addr string, h *host, conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	s.mu.Lock()
+	connect := h.connect
+	s.mu.Unlock()
+
+	if connect != nil {
+		connect(conn, "")
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		query := strings.TrimRight(line, "\r\n")
+
+		s.mu.Lock()
+		s.queries = append(s.queries, Query{Addr: addr, Query: query})
+		handler, ok := h.handlers[strings.ToLower(strings.TrimSpace(query))]
+		if !ok {
+			handler = h.fallback
+		}
+		s.mu.Unlock()
+
+		if handler == nil {
+			handler = Text(fmt.Sprintf("No match for \"%s\".\n", strings.ToUpper(query)))
+		}
+
+		handler(conn, query)
+
+		// 普通的whois服务器每个连接只响应一次查询
+		if connect == nil {
+			return
+		}
+	}
+}
+
+// hostKey returns the lowercase host address with the default port
+func hostKey(addr string) string {
+	addr = strings.ToLower(addr)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, defaultPort)
+	}
+
+	return addr
+}
+
+// Text returns a handler which responds the text
+func Text(text string) Handler {
+	return func(conn net.Conn, query string) {
+		_, _ = io.WriteString(conn, text)
+	}
+}
+
+// Slow returns a handler which responds the text after the delay
+func Slow(delay time.Duration, text string) Handler {
+	return func(conn net.Conn, query string) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		// 客户端提前关闭连接时不再等待
+		closed := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(io.Discard, conn)
+			close(closed)
+		}()
+
+		select {
+		case <-timer.C:
+			_, _ = io.WriteString(conn, text)
+		case <-closed:
+		}
+	}
+}
+
+// Truncated returns a handler which responds the first n bytes of the text and then resets the connection
+func Truncated(text string, n int) Handler {
+	return func(conn net.Conn, query string) {
+		size := n
+		if size > len(text) {
+			size = len(text)
+		}
+		_, _ = io.WriteString(conn, text[:size])
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			// 关闭时发送RST而不是FIN，客户端读取时返回错误
+			_ = tcp.SetLinger(0)
+		}
+	}
+}
+
+// Hang returns a handler which never responds until the client or the server closes the connection
+func Hang() Handler {
+	return func(conn net.Conn, query string) {
+		_, _ = io.Copy(io.Discard, conn)
+	}
+}
@@ -0,0 +1,121 @@
+package whoistest
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func query(t *testing.T, s *Server, addr, q string) (string, error) {
+	t.Helper()
+
+	conn, err := s.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(conn, q+"\r\n"); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(conn)
+
+	return string(data), err
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.HandleText("whois.iana.org", "com", "refer: whois.verisign-grs.com\n")
+	s.Handle("whois.iana.org", "slow", Slow(50*time.Millisecond, "slow\n"))
+	s.Handle("whois.iana.org", "truncated", Truncated("Domain Name: EXAMPLE.COM\n", 6))
+	s.Handle("whois.iana.org", "hang", Hang())
+	s.HandleText("rwhois.example.net:4321", "", "%rwhois V-1.5\n")
+
+	if s.Addr("WHOIS.IANA.ORG:43") == "" {
+		t.Fatalf("host is not registered")
+	}
+
+	data, err := query(t, s, "whois.iana.org:43", "COM")
+	if err != nil || data != "refer: whois.verisign-grs.com\n" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	data, err = query(t, s, "whois.iana.org:43", "net")
+	if err != nil || !strings.HasPrefix(data, "No match for") {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	start := time.Now()
+	data, err = query(t, s, "whois.iana.org:43", "slow")
+	if err != nil || data != "slow\n" || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	data, err = query(t, s, "whois.iana.org:43", "truncated")
+	if err == nil || data != "Domain" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	_, err = query(t, s, "whois.iana.org:43", "hang")
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+
+	data, err = query(t, s, "rwhois.example.net:4321", "example.net")
+	if err != nil || data != "%rwhois V-1.5\n" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	if _, err = query(t, s, "whois.example.org:43", "example.org"); err == nil {
+		t.Fatalf("expect dial error of unknown host")
+	}
+
+	queries := s.Queries()
+	if len(queries) != 6 || queries[0] != (Query{Addr: "whois.iana.org:43", Query: "COM"}) {
+		t.Fatalf("got queries %v", queries)
+	}
+}
+
+func TestServer_HandleConnect(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.HandleConnect("rwhois.example.net:4321", Text("%rwhois V-1.5\r\n"))
+	s.HandleText("rwhois.example.net:4321", "192.0.2.1", "network:Network-Name:EXAMPLE-NET\r\n%ok\r\n")
+	s.HandleText("rwhois.example.net:4321", "", "%error 230 No Objects Found\r\n")
+
+	conn, err := s.DialContext(context.Background(), "tcp", "rwhois.example.net:4321")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	// 服务器先发送banner，然后在同一连接上响应多个查询
+	expect := func(want string) {
+		t.Helper()
+		line, err := reader.ReadString('\n')
+		if err != nil || line != want {
+			t.Fatalf("expect %q, got %q, %v", want, line, err)
+		}
+	}
+	expect("%rwhois V-1.5\r\n")
+	_, _ = io.WriteString(conn, "192.0.2.1\r\n")
+	expect("network:Network-Name:EXAMPLE-NET\r\n")
+	expect("%ok\r\n")
+	_, _ = io.WriteString(conn, "192.0.2.2\r\n")
+	expect("%error 230 No Objects Found\r\n")
+
+	queries := s.Queries()
+	if len(queries) != 2 || queries[1] != (Query{Addr: "rwhois.example.net:4321", Query: "192.0.2.2"}) {
+		t.Fatalf("got queries %v", queries)
+	}
+}